@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
-// cleanupFolderDir removes symlinks and handles remaining files in the folder directory
-func cleanupFolderDir(folderDir, cwd string) error {
+// cleanupFolderDir removes symlinks and handles remaining files in the folder directory.
+// Files the user discards are moved into trash rather than deleted.
+func cleanupFolderDir(folderDir, cwd string, trash *trashEntry) error {
 	entries, err := os.ReadDir(folderDir)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -16,7 +18,7 @@ func cleanupFolderDir(folderDir, cwd string) error {
 		return err
 	}
 
-	fmt.Printf("\nCleaning up folder directory %s\n", folderDir)
+	fmt.Fprintf(textOut, "\nCleaning up folder directory %s\n", folderDir)
 
 	var symlinks []string
 	var regularFiles []string
@@ -41,32 +43,32 @@ func cleanupFolderDir(folderDir, cwd string) error {
 		if err := os.Remove(path); err != nil {
 			fmt.Fprintf(os.Stderr, "  Warning: could not remove symlink %s: %v\n", name, err)
 		} else {
-			fmt.Printf("  Removed symlink: %s\n", name)
+			fmt.Fprintf(textOut, "  Removed symlink: %s\n", name)
 		}
 	}
 
 	// Handle remaining regular files
 	if len(regularFiles) > 0 {
-		fmt.Printf("\nThe following non-symlink files remain in %s:\n", folderDir)
+		fmt.Fprintf(textOut, "\nThe following non-symlink files remain in %s:\n", folderDir)
 		for _, name := range regularFiles {
-			fmt.Printf("  - %s\n", name)
+			fmt.Fprintf(textOut, "  - %s\n", name)
 		}
 
 		idx := runSelect("What would you like to do?", []string{
-			"Remove them permanently",
+			"Move them to the trash",
 			"Move them to current working directory",
 			"Do nothing (leave them)",
 		})
 
 		switch idx {
 		case 0:
-			// Remove files permanently
+			// Move files to the trash, where they can be restored until purged
 			for _, name := range regularFiles {
 				path := filepath.Join(folderDir, name)
-				if err := os.RemoveAll(path); err != nil {
-					fmt.Fprintf(os.Stderr, "  Warning: could not remove %s: %v\n", name, err)
+				if err := trash.move(path, "folder/"+name, trashItem{}); err != nil {
+					fmt.Fprintf(os.Stderr, "  Warning: could not move %s to the trash: %v\n", name, err)
 				} else {
-					fmt.Printf("  Removed: %s\n", name)
+					fmt.Fprintf(textOut, "  Trashed: %s\n", name)
 				}
 			}
 		case 1:
@@ -84,13 +86,72 @@ func cleanupFolderDir(folderDir, cwd string) error {
 				if err := os.Rename(srcPath, dstPath); err != nil {
 					fmt.Fprintf(os.Stderr, "  Warning: could not move %s: %v\n", name, err)
 				} else {
-					fmt.Printf("  Moved: %s -> %s\n", name, dstPath)
+					fmt.Fprintf(textOut, "  Moved: %s -> %s\n", name, dstPath)
 				}
 			}
 		case 2, -1:
-			fmt.Println("Leaving files as-is.")
+			fmt.Fprintln(textOut, "Leaving files as-is.")
 		}
 	}
 
 	return nil
 }
+
+// removeEmptyFolderDir removes the folder directory if nothing is left in it
+func removeEmptyFolderDir(folderDir string) {
+	entries, err := os.ReadDir(folderDir)
+	if err == nil && len(entries) == 0 {
+		if err := os.Remove(folderDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not remove empty folder directory %s: %v\n", folderDir, err)
+		} else {
+			fmt.Fprintf(textOut, "Removed empty folder directory %s\n", folderDir)
+		}
+	} else if err == nil && len(entries) > 0 {
+		fmt.Fprintf(textOut, "Folder directory %s not removed (still contains files)\n", folderDir)
+	}
+}
+
+// moveFolderLeftovers empties a renamed folder's old directory once its worktrees have
+// moved: symlinks to the root are dropped, since they are recreated at the new location,
+// and any other files are moved along
+func moveFolderLeftovers(oldDir, newDir string) error {
+	entries, err := os.ReadDir(oldDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var failed []string
+	for _, entry := range entries {
+		name := entry.Name()
+		oldPath, newPath := filepath.Join(oldDir, name), filepath.Join(newDir, name)
+		info, err := os.Lstat(oldPath)
+		if err != nil {
+			continue
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(oldPath); err != nil {
+				failed = append(failed, name)
+			}
+			continue
+		}
+		if _, err := os.Lstat(newPath); err == nil {
+			failed = append(failed, name)
+			continue
+		}
+		if err := os.Rename(oldPath, newPath); err != nil {
+			failed = append(failed, name)
+			continue
+		}
+		fmt.Fprintf(textOut, "  Moved: %s\n", name)
+	}
+
+	removeEmptyFolderDir(oldDir)
+	if len(failed) > 0 {
+		return fmt.Errorf("could not move %s out of %s", strings.Join(failed, ", "), oldDir)
+	}
+	return nil
+}
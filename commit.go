@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+func runCommit(args []string) int {
+	fs := newFlagSet("commit")
+	messageFlag := fs.String("m", "", "Commit message, used in every repo (required)")
+	dryRunFlag := fs.Bool("dry-run", false, "Show what would be committed without committing")
+	jobsFlag := fs.Int("jobs", 0, "Number of repos to process in parallel (default 4, or jobs in config; 1 streams output)")
+	formatFlag := addFormatFlag(fs)
+
+	args, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(args) != 1 {
+		return usageError(fs, "expected exactly one folder name")
+	}
+	if *messageFlag == "" {
+		return usageError(fs, "a commit message is required (-m)")
+	}
+	folderName := args[0]
+
+	out, err := setupOutput(*formatFlag)
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+	target, err := loadFolderTarget(ws, folderName)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	fmt.Fprintf(textOut, "Committing changes in %d worktrees of folder '%s'\n", len(target.dirs), folderName)
+
+	// Ctrl-C cancels repos that haven't started yet
+	ctx, stop := interruptContext()
+	defer stop()
+	startedAt := time.Now()
+
+	results := runRepos(ctx, target.dirs, resolveJobs(ws.config, *jobsFlag), func(ctx context.Context, dir string, log *repoLog) (string, error) {
+		path := target.records[dir].Path
+		detail, err := commitWorktree(log, path, *messageFlag, *dryRunFlag)
+		if out != nil && out.stream {
+			out.write(line("repo_result", toJSONRepoResult(repoResult{Dir: dir, Detail: detail, Err: err}, path, "")))
+		}
+		return detail, err
+	})
+	printSummaryTable(results)
+
+	if out != nil {
+		doc := newRunResult("commit", folderName, target.info.Branch, startedAt, results, false)
+		for i, r := range results {
+			doc.Repos[i].Path = target.records[r.Dir].Path
+		}
+		out.write(doc)
+	}
+
+	return exitCodeFor(ctx, countFailed(results))
+}
+
+// commitWorktree stages everything in the worktree and commits it. Clean worktrees are skipped.
+func commitWorktree(log *repoLog, worktreePath, message string, dryRun bool) (string, error) {
+	if _, err := os.Stat(worktreePath); err != nil {
+		return "", fmt.Errorf("worktree does not exist at %s", worktreePath)
+	}
+
+	files, err := changedFiles(worktreePath)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		log.Printf("Nothing to commit")
+		return "skipped: clean", nil
+	}
+
+	n := len(files)
+	if dryRun {
+		log.Printf("Would commit %d %s: %s", n, plural(n, "file", "files"), summarizeList(files, 5))
+		return fmt.Sprintf("would commit %d %s", n, plural(n, "file", "files")), nil
+	}
+
+	log.Printf("Committing %d %s", n, plural(n, "file", "files"))
+	for _, args := range [][]string{
+		{"add", "--all"},
+		{"commit", "--quiet", "-m", message},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = worktreePath
+		cmd.Stdout = log.Stdout()
+		cmd.Stderr = log.Stderr()
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("git %s failed: %w", args[0], err)
+		}
+	}
+
+	head, err := headCommit(worktreePath)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Committed %s", head)
+	return fmt.Sprintf("committed %d %s as %s", n, plural(n, "file", "files"), head), nil
+}
+
+func runPush(args []string) int {
+	fs := newFlagSet("push")
+	remoteFlag := fs.String("remote", "", "Remote to push branches without an upstream to (default: the first configured remote)")
+	dryRunFlag := fs.Bool("dry-run", false, "Show what would be pushed without pushing")
+	jobsFlag := fs.Int("jobs", 0, "Number of repos to process in parallel (default 4, or jobs in config; 1 streams output)")
+	formatFlag := addFormatFlag(fs)
+
+	args, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(args) != 1 {
+		return usageError(fs, "expected exactly one folder name")
+	}
+	folderName := args[0]
+
+	out, err := setupOutput(*formatFlag)
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+	target, err := loadFolderTarget(ws, folderName)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	fmt.Fprintf(textOut, "Pushing %d worktrees of folder '%s'\n", len(target.dirs), folderName)
+
+	// Ctrl-C cancels repos that haven't started yet
+	ctx, stop := interruptContext()
+	defer stop()
+	startedAt := time.Now()
+
+	results := runRepos(ctx, target.dirs, resolveJobs(ws.config, *jobsFlag), func(ctx context.Context, dir string, log *repoLog) (string, error) {
+		path := target.records[dir].Path
+		remote := *remoteFlag
+		if remote == "" {
+			remote = resolveRemotes(ws.config, filepath.Base(dir))[0]
+		}
+		detail, err := pushWorktree(log, path, remote, *dryRunFlag)
+		if out != nil && out.stream {
+			out.write(line("repo_result", toJSONRepoResult(repoResult{Dir: dir, Detail: detail, Err: err}, path, "")))
+		}
+		return detail, err
+	})
+	printSummaryTable(results)
+
+	if out != nil {
+		doc := newRunResult("push", folderName, target.info.Branch, startedAt, results, false)
+		for i, r := range results {
+			doc.Repos[i].Path = target.records[r.Dir].Path
+		}
+		out.write(doc)
+	}
+
+	return exitCodeFor(ctx, countFailed(results))
+}
+
+// pushWorktree pushes the worktree's branch. A branch without an upstream of the same name
+// is pushed to remote and starts tracking it; one that is up to date with its upstream is
+// skipped.
+func pushWorktree(log *repoLog, worktreePath, remote string, dryRun bool) (string, error) {
+	if _, err := os.Stat(worktreePath); err != nil {
+		return "", fmt.Errorf("worktree does not exist at %s", worktreePath)
+	}
+
+	branch := currentBranch(worktreePath)
+	if branch == "" {
+		return "", fmt.Errorf("HEAD is detached, nothing to push")
+	}
+
+	var args []string
+	var detail string
+	// An upstream named differently, like the base a branch was created from, isn't where
+	// the branch goes
+	if upstream := upstreamBranch(worktreePath); upstream != "" && upstream == upstreamRemote(worktreePath, branch)+"/"+branch {
+		ahead, _, err := aheadBehind(worktreePath, upstream)
+		if err != nil {
+			return "", err
+		}
+		if ahead == 0 {
+			log.Printf("'%s' is up to date with '%s'", branch, upstream)
+			return "skipped: up to date", nil
+		}
+		args = []string{"push"}
+		detail = fmt.Sprintf("%d %s to %s", ahead, plural(ahead, "commit", "commits"), upstream)
+	} else {
+		if !slices.Contains(listRemotes(worktreePath), remote) {
+			return "", fmt.Errorf("remote '%s' is not configured", remote)
+		}
+		args = []string{"push", "--set-upstream", remote, branch}
+		detail = fmt.Sprintf("new branch %s/%s", remote, branch)
+	}
+
+	if dryRun {
+		log.Printf("Would push %s", detail)
+		return "would push " + detail, nil
+	}
+
+	// Like every other call to a remote, fail on a missing credential instead of prompting
+	// for it, which the live view's raw terminal couldn't show anyway
+	log.Printf("Pushing %s", detail)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = worktreePath
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Stdout = log.Stdout()
+	cmd.Stderr = log.Stderr()
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git push failed: %w", err)
+	}
+	return "pushed " + detail, nil
+}
@@ -4,25 +4,81 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
 	"time"
 )
 
 // FolderInfo holds information about a folder
 type FolderInfo struct {
-	Branch    string    `json:"branch"`
-	LastUsed  time.Time `json:"last_used"`
-	IsActive  bool      `json:"is_active"` // true if worktrees currently exist
+	Branch    string       `json:"branch"`
+	LastUsed  time.Time    `json:"last_used"`
+	IsActive  bool         `json:"is_active"` // true if worktrees currently exist
+	CreatedAt time.Time    `json:"created_at,omitzero"`
+	Repos     []RepoRecord `json:"repos,omitempty"` // repos the folder was created with; empty for older entries
+
+	// Per-repo branches when the repos of the folder aren't all on Branch, keyed by repo
+	// name with "*" for repos not listed. Empty when every repo uses Branch.
+	Branches map[string]string `json:"branches,omitempty"`
+
+	Sync *SyncState `json:"sync,omitempty"` // unfinished sync waiting for -continue or -abort
+}
+
+// SyncState remembers where a sync stopped so that it can be continued or aborted
+type SyncState struct {
+	Mode       string   `json:"mode"` // "rebase" or "merge"
+	KeepGoing  bool     `json:"keep_going,omitempty"`
+	Conflicted []string `json:"conflicted,omitempty"` // main checkouts whose worktree stopped on a conflict
+	Pending    []string `json:"pending,omitempty"`    // main checkouts not synced yet
+}
+
+// RepoRecord describes one repository's worktree within a folder
+type RepoRecord struct {
+	Name    string `json:"name"`
+	Dir     string `json:"dir"`              // absolute path of the main checkout
+	Path    string `json:"path"`             // absolute path of the worktree
+	Branch  string `json:"branch,omitempty"` // branch the worktree was created on; empty for older entries
+	BaseRef string `json:"base_ref,omitempty"`
+}
+
+// RepoConfig holds per-repository settings, keyed by directory name
+type RepoConfig struct {
+	BaseRef string        `json:"base_ref,omitempty"` // ref that new branches start from
+	Remotes []string      `json:"remotes,omitempty"`  // remotes searched for existing branches, in order
+	Ignored []IgnoredRule `json:"ignored,omitempty"`  // checked before the workspace rules
+}
+
+// IgnoredRule picks what a new worktree gets for the gitignored items matching Pattern
+type IgnoredRule struct {
+	Pattern string `json:"pattern"` // glob matched against the item's path and its base name
+	Action  string `json:"action"`  // share, copy, reflink, skip or fresh
+
+	// Hardlink lets copies fall back to hardlinks when reflinks aren't available. Only
+	// for read-mostly trees such as node_modules: a file changed in place changes in
+	// every worktree.
+	Hardlink bool `json:"hardlink,omitempty"`
 }
 
 // Config holds folder history with timestamps
 type Config struct {
-	Folders map[string]*FolderInfo `json:"folders,omitempty"`
+	BaseRef       string                 `json:"base_ref,omitempty"`       // default ref that new branches start from
+	Remotes       []string               `json:"remotes,omitempty"`        // default remote search order
+	Offline       bool                   `json:"offline,omitempty"`        // never contact remotes, use refs/remotes/* only
+	RemoteTimeout string                 `json:"remote_timeout,omitempty"` // ls-remote limit as a Go duration, e.g. "5s"
+	Jobs          int                    `json:"jobs,omitempty"`           // repos processed at once
+	Ignored       []IgnoredRule          `json:"ignored,omitempty"`        // what to do with gitignored items, first match wins
+	Repos         map[string]*RepoConfig `json:"repos,omitempty"`
+	Folders       map[string]*FolderInfo `json:"folders,omitempty"`
 }
 
 const configFileName = ".worktree_plus.json"
 
+// defaultRemote is searched when no remotes are configured
+const defaultRemote = "origin"
+
 // loadConfig loads the config file from the given directory
 func loadConfig(dir string) (*Config, error) {
 	configPath := filepath.Join(dir, configFileName)
@@ -61,29 +117,313 @@ func saveConfig(dir string, config *Config) error {
 	return os.WriteFile(configPath, data, 0644)
 }
 
-// findFolderByBranch looks up a folder name by branch name in active folders
+// resolveBaseRef returns the ref a new branch in the given repo should start from.
+// The -from flag wins over the per-repo setting, which wins over the workspace default.
+// An empty result means the main checkout's current HEAD.
+func resolveBaseRef(config *Config, repoName, fromFlag string) string {
+	if fromFlag != "" {
+		return fromFlag
+	}
+	if repo, ok := config.Repos[repoName]; ok && repo.BaseRef != "" {
+		return repo.BaseRef
+	}
+	return config.BaseRef
+}
+
+// resolveRemotes returns the remotes to search for an existing branch in the given repo, in order
+func resolveRemotes(config *Config, repoName string) []string {
+	if repo, ok := config.Repos[repoName]; ok && len(repo.Remotes) > 0 {
+		return repo.Remotes
+	}
+	if len(config.Remotes) > 0 {
+		return config.Remotes
+	}
+	return []string{defaultRemote}
+}
+
+// resolveIgnoredRules returns the rules for gitignored items in the given repo: its own
+// rules first, then the workspace rules
+func resolveIgnoredRules(config *Config, repoName string) []IgnoredRule {
+	var rules []IgnoredRule
+	if repo, ok := config.Repos[repoName]; ok {
+		rules = append(rules, repo.Ignored...)
+	}
+	return append(rules, config.Ignored...)
+}
+
+// validateIgnoredRules checks every rule for gitignored items in the config
+func validateIgnoredRules(config *Config) error {
+	check := func(where string, rules []IgnoredRule) error {
+		for _, rule := range rules {
+			if _, err := path.Match(rule.Pattern, ""); err != nil || rule.Pattern == "" {
+				return fmt.Errorf("invalid pattern '%s' in %s ignored rules", rule.Pattern, where)
+			}
+			if !slices.Contains(ignoredActions, rule.Action) {
+				return fmt.Errorf("invalid action '%s' for '%s' in %s ignored rules (expected one of %s)", rule.Action, rule.Pattern, where, strings.Join(ignoredActions, ", "))
+			}
+		}
+		return nil
+	}
+	if err := check("workspace", config.Ignored); err != nil {
+		return err
+	}
+	for name, repo := range config.Repos {
+		if err := check("repo '"+name+"'", repo.Ignored); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveRemoteTimeout returns the ls-remote timeout: the flag if set, then the config, then the default
+func resolveRemoteTimeout(config *Config, flagValue time.Duration) (time.Duration, error) {
+	if flagValue > 0 {
+		return flagValue, nil
+	}
+	if config.RemoteTimeout != "" {
+		d, err := time.ParseDuration(config.RemoteTimeout)
+		if err != nil {
+			return 0, fmt.Errorf("invalid remote_timeout '%s': %w", config.RemoteTimeout, err)
+		}
+		return d, nil
+	}
+	return defaultRemoteTimeout, nil
+}
+
+// resolveJobs returns how many repos to process at once: the flag if set, then the config, then the default
+func resolveJobs(config *Config, flagValue int) int {
+	if flagValue > 0 {
+		return flagValue
+	}
+	if config.Jobs > 0 {
+		return config.Jobs
+	}
+	return defaultJobs
+}
+
+// findFolderByBranch looks up a folder name by branch name in active folders. Besides a
+// folder's main branch, its per-repo branches match too, except the "*" fallback.
 func findFolderByBranch(config *Config, branchName string) (string, bool) {
 	for folder, info := range config.Folders {
-		if info.IsActive && info.Branch == branchName {
+		if !info.IsActive {
+			continue
+		}
+		if info.Branch == branchName {
 			return folder, true
 		}
+		for repo, branch := range info.Branches {
+			if repo != anyRepo && branch == branchName {
+				return folder, true
+			}
+		}
 	}
 	return "", false
 }
 
-// touchFolder updates the last used time for a folder and marks it active
-func touchFolder(config *Config, folderName, branchName string) {
+// anyRepo is the branchSpec key for repos that aren't listed
+const anyRepo = "*"
+
+// branchSpec says which branch each repo of a folder uses, keyed by repo name with
+// anyRepo for the rest. A plain branch name means every repo uses that branch.
+type branchSpec map[string]string
+
+// parseBranchSpec parses a branch name, or per-repo branches like "api=feature-x,*=main"
+func parseBranchSpec(value string) (branchSpec, error) {
+	if !strings.Contains(value, "=") {
+		if value == "" || strings.Contains(value, ",") {
+			return nil, fmt.Errorf("invalid branch '%s'", value)
+		}
+		return branchSpec{anyRepo: value}, nil
+	}
+
+	spec := make(branchSpec)
+	for _, part := range strings.Split(value, ",") {
+		repo, branch, ok := strings.Cut(strings.TrimSpace(part), "=")
+		repo, branch = strings.TrimSpace(repo), strings.TrimSpace(branch)
+		if !ok || repo == "" || branch == "" {
+			return nil, fmt.Errorf("invalid branch mapping '%s' (expected repo=branch)", part)
+		}
+		if _, dup := spec[repo]; dup {
+			return nil, fmt.Errorf("repo '%s' is mapped twice", repo)
+		}
+		spec[repo] = branch
+	}
+	return spec, nil
+}
+
+// branchFor returns the branch for a repo, and false if the spec leaves the repo out
+func (s branchSpec) branchFor(repoName string) (string, bool) {
+	if branch, ok := s[repoName]; ok {
+		return branch, true
+	}
+	branch, ok := s[anyRepo]
+	return branch, ok
+}
+
+// single reports whether every repo uses the same branch
+func (s branchSpec) single() bool {
+	_, ok := s[anyRepo]
+	return len(s) == 1 && ok
+}
+
+// primary returns the branch that names the folder: the first listed repo's branch in
+// name order, or the fallback branch when no repo is listed
+func (s branchSpec) primary() string {
+	var repos []string
+	for repo := range s {
+		if repo != anyRepo {
+			repos = append(repos, repo)
+		}
+	}
+	if len(repos) == 0 {
+		return s[anyRepo]
+	}
+	sort.Strings(repos)
+	return s[repos[0]]
+}
+
+// String formats the spec the way parseBranchSpec reads it, listed repos first in name order
+func (s branchSpec) String() string {
+	if s.single() {
+		return s[anyRepo]
+	}
+	var parts []string
+	for repo, branch := range s {
+		if repo != anyRepo {
+			parts = append(parts, repo+"="+branch)
+		}
+	}
+	sort.Strings(parts)
+	if branch, ok := s[anyRepo]; ok {
+		parts = append(parts, anyRepo+"="+branch)
+	}
+	return strings.Join(parts, ",")
+}
+
+// spec returns the folder's branches as a branchSpec
+func (info *FolderInfo) spec() branchSpec {
+	if len(info.Branches) > 0 {
+		return branchSpec(info.Branches)
+	}
+	return branchSpec{anyRepo: info.Branch}
+}
+
+// repoBranch returns the branch a repo of the folder is on, as far as the config knows
+func (info *FolderInfo) repoBranch(repo RepoRecord) string {
+	if repo.Branch != "" {
+		return repo.Branch
+	}
+	if branch, ok := info.spec().branchFor(repo.Name); ok {
+		return branch
+	}
+	return info.Branch
+}
+
+// touchFolder updates the last used time for a folder and marks it active on the branches
+// in spec. Activating an inactive folder starts a new session with an empty repo list.
+func touchFolder(config *Config, folderName string, spec branchSpec) {
+	now := time.Now()
+	info, exists := config.Folders[folderName]
+	if !exists {
+		info = &FolderInfo{CreatedAt: now}
+		config.Folders[folderName] = info
+	} else if !info.IsActive {
+		info.CreatedAt = now
+		info.Repos = nil
+	}
+	info.LastUsed = now
+	info.IsActive = true
+	info.Branch = spec.primary()
+	info.Branches = nil
+	if !spec.single() {
+		info.Branches = spec
+	}
+}
+
+// recordRepos adds repos to a folder's record, replacing any earlier entry for the same checkout
+func recordRepos(config *Config, folderName string, repos []RepoRecord) {
+	info, exists := config.Folders[folderName]
+	if !exists {
+		return
+	}
+	for _, repo := range repos {
+		replaced := false
+		for i := range info.Repos {
+			if info.Repos[i].Dir == repo.Dir {
+				info.Repos[i] = repo
+				replaced = true
+			}
+		}
+		if !replaced {
+			info.Repos = append(info.Repos, repo)
+		}
+	}
+}
+
+// findRepoRecord returns the record for the main checkout dir in a folder
+func findRepoRecord(info *FolderInfo, dir string) (RepoRecord, bool) {
+	if info == nil {
+		return RepoRecord{}, false
+	}
+	for _, repo := range info.Repos {
+		if repo.Dir == dir {
+			return repo, true
+		}
+	}
+	return RepoRecord{}, false
+}
+
+// findRepoRecordByName returns the record for the repo with the given name in a folder
+func findRepoRecordByName(info *FolderInfo, name string) (RepoRecord, bool) {
+	for _, repo := range info.Repos {
+		if repo.Name == name {
+			return repo, true
+		}
+	}
+	return RepoRecord{}, false
+}
+
+// recordedDirs returns the main checkout directories recorded for a folder
+func recordedDirs(info *FolderInfo) []string {
+	if info == nil {
+		return nil
+	}
+	dirs := make([]string, len(info.Repos))
+	for i, repo := range info.Repos {
+		dirs[i] = repo.Dir
+	}
+	return dirs
+}
+
+// switchFolder points an active folder at a different branch for all its repos
+func switchFolder(config *Config, folderName, branchName string) {
 	if info, exists := config.Folders[folderName]; exists {
-		info.LastUsed = time.Now()
-		info.IsActive = true
 		info.Branch = branchName
-	} else {
-		config.Folders[folderName] = &FolderInfo{
-			Branch:   branchName,
-			LastUsed: time.Now(),
-			IsActive: true,
+		info.Branches = nil
+		info.LastUsed = time.Now()
+	}
+}
+
+// renameFolderBranch moves the folder's repos on branch oldName to newName, keeping the
+// other per-repo branches
+func renameFolderBranch(config *Config, folderName, oldName, newName string) {
+	info, exists := config.Folders[folderName]
+	if !exists {
+		return
+	}
+	spec := make(branchSpec)
+	for repo, branch := range info.spec() {
+		if branch == oldName {
+			branch = newName
 		}
+		spec[repo] = branch
+	}
+	info.Branch = spec.primary()
+	info.Branches = nil
+	if !spec.single() {
+		info.Branches = spec
 	}
+	info.LastUsed = time.Now()
 }
 
 // deactivateFolder marks a folder as inactive but keeps it in history
@@ -91,32 +431,61 @@ func deactivateFolder(config *Config, folderName string) {
 	if info, exists := config.Folders[folderName]; exists {
 		info.IsActive = false
 		info.LastUsed = time.Now()
+		info.Sync = nil
 	}
 }
 
 // FolderHistory represents a folder with its history info for display
 type FolderHistory struct {
-	Name     string
-	Branch   string
-	LastUsed time.Time
-	IsActive bool
+	Name      string
+	Branch    string
+	LastUsed  time.Time
+	IsActive  bool
+	CreatedAt time.Time
+	Repos     []RepoRecord
+	Branches  map[string]string
+}
+
+// branchLabel describes the folder's branch, listing per-repo branches when it has them
+func (f FolderHistory) branchLabel() string {
+	if len(f.Branches) > 0 {
+		return branchSpec(f.Branches).String()
+	}
+	return f.Branch
 }
 
-// checkBranchConflict checks if a branch is already active with a different folder
-// Returns the conflicting folder name if there's a conflict, empty string otherwise
-func checkBranchConflict(config *Config, folderName, branchName string) string {
+// checkBranchConflict checks whether another active folder already has one of the given
+// repos on the branch spec assigns it. Returns the conflicting folder and branch, or
+// empty strings if there's no conflict.
+func checkBranchConflict(config *Config, folderName string, spec branchSpec, repoNames []string) (string, string) {
 	for folder, info := range config.Folders {
-		if info.IsActive && info.Branch == branchName && folder != folderName {
-			return folder
+		if !info.IsActive || folder == folderName {
+			continue
+		}
+		for _, name := range repoNames {
+			branch, ok := spec.branchFor(name)
+			if !ok {
+				continue
+			}
+			repo, recorded := findRepoRecordByName(info, name)
+			if !recorded && len(info.Repos) > 0 {
+				continue // the folder doesn't have this repo
+			}
+			if !recorded {
+				repo = RepoRecord{Name: name}
+			}
+			if info.repoBranch(repo) == branch {
+				return folder, branch
+			}
 		}
 	}
-	return ""
+	return "", ""
 }
 
-// isExactMatch checks if folder+branch exactly matches an active session
-func isExactMatch(config *Config, folderName, branchName string) bool {
+// isExactMatch checks if folder+branches exactly matches an active session
+func isExactMatch(config *Config, folderName string, spec branchSpec) bool {
 	if info, exists := config.Folders[folderName]; exists {
-		return info.IsActive && info.Branch == branchName
+		return info.IsActive && info.spec().String() == spec.String()
 	}
 	return false
 }
@@ -126,10 +495,13 @@ func getRecentFolders(config *Config) []FolderHistory {
 	var folders []FolderHistory
 	for name, info := range config.Folders {
 		folders = append(folders, FolderHistory{
-			Name:     name,
-			Branch:   info.Branch,
-			LastUsed: info.LastUsed,
-			IsActive: info.IsActive,
+			Name:      name,
+			Branch:    info.Branch,
+			LastUsed:  info.LastUsed,
+			IsActive:  info.IsActive,
+			CreatedAt: info.CreatedAt,
+			Repos:     info.Repos,
+			Branches:  info.Branches,
 		})
 	}
 
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+func runCreate(args []string) int {
+	fs := newFlagSet("create")
+	dirsFlag := fs.String("dirs", "", "Comma-separated list of directories to create worktrees for. If not set, uses all directories with .git subfolder")
+	folderFlag := fs.String("folder", "", "Custom folder name for the worktree (defaults to branch name). Mapping is saved for later use.")
+	fromFlag := fs.String("from", "", "Ref that new branches start from (e.g. origin/main, a tag or a commit). Overrides base_ref in the config.")
+	fetchFlag := fs.Bool("fetch", false, "Fetch the configured remotes in all target repos in parallel before creating worktrees")
+	fetchTimeoutFlag := fs.Duration("fetch-timeout", defaultFetchTimeout, "Timeout for each git fetch")
+	offlineFlag := fs.Bool("offline", false, "Don't contact remotes; only use locally known remote branches")
+	remoteTimeoutFlag := fs.Duration("remote-timeout", 0, "Timeout for each remote branch lookup before falling back to local refs (default 15s, or remote_timeout in config)")
+	atomicFlag := fs.Bool("atomic", false, "All-or-nothing creation: if any repo fails or Ctrl-C is pressed, undo the repos that succeeded")
+	jobsFlag := fs.Int("jobs", 0, "Number of repos to process in parallel (default 4, or jobs in config; 1 streams output)")
+	formatFlag := addFormatFlag(fs)
+
+	args, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(args) != 1 {
+		return usageError(fs, "expected exactly one branch name or repo=branch mapping")
+	}
+	spec, err := parseBranchSpec(args[0])
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+	branchName := spec.primary()
+
+	out, err := setupOutput(*formatFlag)
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+	config := ws.config
+
+	offline := *offlineFlag || config.Offline
+	remoteTimeout, err := resolveRemoteTimeout(config, *remoteTimeoutFlag)
+	if err != nil {
+		return fail("%v", err)
+	}
+	if err := validateIgnoredRules(config); err != nil {
+		return fail("%v", err)
+	}
+
+	// Determine which directories to process
+	var targetDirs []string
+	if *dirsFlag != "" {
+		targetDirs = ws.parseDirs(*dirsFlag)
+	} else {
+		// Find all directories with .git subfolder
+		targetDirs, err = findGitDirs(ws.cwd)
+		if err != nil {
+			return fail("finding git directories: %v", err)
+		}
+	}
+
+	// With per-repo branches, repos the mapping leaves out get no worktree
+	var repoNames []string
+	if !spec.single() {
+		var mapped []string
+		for _, dir := range targetDirs {
+			if _, ok := spec.branchFor(filepath.Base(dir)); ok {
+				mapped = append(mapped, dir)
+			} else {
+				fmt.Fprintf(textOut, "Skipping %s: no branch mapped for it\n", filepath.Base(dir))
+			}
+		}
+		targetDirs = mapped
+	}
+	for _, dir := range targetDirs {
+		repoNames = append(repoNames, filepath.Base(dir))
+	}
+
+	if len(targetDirs) == 0 {
+		return fail("no directories found to process")
+	}
+
+	// Determine folder name
+	var folderName string
+	if *folderFlag != "" {
+		// Use specified folder name
+		folderName = *folderFlag
+	} else if existingFolder, ok := findFolderByBranch(config, branchName); ok {
+		// Look up existing active mapping by branch name
+		folderName = existingFolder
+		fmt.Fprintf(textOut, "Using existing mapping: folder '%s' -> branch '%s'\n", folderName, branchName)
+	} else {
+		// Without -folder, offer folder selection
+		folderName, ok = selectFolderForBranch(config, branchName)
+		if !ok {
+			fmt.Fprintln(textOut, "Cancelled.")
+			return exitOK
+		}
+	}
+
+	// Check if this exact folder+branch is already active
+	if isExactMatch(config, folderName, spec) {
+		fmt.Fprintf(textOut, "Worktrees for folder '%s' with branch '%s' already exist. Nothing to do.\n", folderName, spec)
+		return exitOK
+	}
+
+	// The worktrees of an active folder stay on their branches, so a different spec would
+	// be recorded for branches they don't have
+	if info := config.Folders[folderName]; info != nil && info.IsActive && len(info.Branches) > 0 {
+		return fail("folder '%s' is active on per-repo branches '%s', not '%s'; use switch, or remove it first", folderName, info.spec(), spec)
+	}
+
+	// Check if a repo's branch is already in use with a different folder
+	if conflictFolder, conflictBranch := checkBranchConflict(config, folderName, spec, repoNames); conflictFolder != "" {
+		code := fail("branch '%s' is already active in folder '%s'", conflictBranch, conflictFolder)
+		fmt.Fprintf(os.Stderr, "Remove the existing worktrees first with: worktree_plus remove -folder %s\n", conflictFolder)
+		return code
+	}
+
+	// Git checks a branch out in one worktree at a time, so fail before touching anything
+	repoBranches := make(map[string]string)
+	for _, dir := range targetDirs {
+		repoBranches[dir], _ = spec.branchFor(filepath.Base(dir))
+	}
+	if err := requireBranchesFree(targetDirs, repoBranches, folderName); err != nil {
+		return fail("cannot create folder '%s' on branch '%s': %v; map those repos to other branches", folderName, spec, err)
+	}
+
+	// Fetch everything up front so branch lookups see the latest remote state
+	if *fetchFlag && offline {
+		fmt.Fprintln(textOut, "Offline mode: skipping fetch")
+	} else if *fetchFlag {
+		if failed := fetchAll(config, targetDirs, *fetchTimeoutFlag); failed > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: fetch failed in %d of %d repositories, continuing with local state\n", failed, len(targetDirs))
+		}
+		fmt.Fprintln(textOut)
+	}
+
+	fmt.Fprintf(textOut, "Processing %d directories for branch '%s' (folder: '%s')\n", len(targetDirs), spec, folderName)
+
+	// Ctrl-C cancels repos that haven't started yet; in atomic mode it also triggers a rollback
+	ctx, stop := interruptContext()
+	defer stop()
+
+	var createdMu sync.Mutex
+	created := make(map[string]createResult)
+	startedAt := time.Now()
+
+	// Process each directory
+	jobs := resolveJobs(config, *jobsFlag)
+	results := runRepos(ctx, targetDirs, jobs, func(ctx context.Context, dir string, log *repoLog) (string, error) {
+		result, err := createWorktree(log, dir, folderName, repoBranches[dir], createOptions{
+			BaseRef:      resolveBaseRef(config, filepath.Base(dir), *fromFlag),
+			Remotes:      resolveRemotes(config, filepath.Base(dir)),
+			Lookup:       remoteLookup{Offline: offline, Timeout: remoteTimeout},
+			FetchTimeout: *fetchTimeoutFlag,
+			Ignored:      resolveIgnoredRules(config, filepath.Base(dir)),
+		})
+		createdMu.Lock()
+		created[dir] = result
+		createdMu.Unlock()
+		if out != nil && out.stream {
+			out.write(line("repo_result", toJSONRepoResult(repoResult{Dir: dir, Detail: result.summary(), Err: err}, result.Path, result.BaseRef)))
+		}
+		return result.summary(), err
+	})
+	if len(results) > 1 {
+		printSummaryTable(results)
+	}
+
+	// emitResult writes the machine-readable result once all repos are done
+	emitResult := func(rolledBack bool) {
+		if out == nil {
+			return
+		}
+		doc := newRunResult("create", folderName, branchName, startedAt, results, rolledBack)
+		for i, r := range results {
+			doc.Repos[i].Path, doc.Repos[i].BaseRef = created[r.Dir].Path, created[r.Dir].BaseRef
+		}
+		out.write(doc)
+	}
+
+	failed := countFailed(results)
+	if *atomicFlag && (failed > 0 || ctx.Err() != nil) {
+		if ctx.Err() != nil {
+			fmt.Fprintln(os.Stderr, "\nInterrupted, rolling back all repositories")
+		} else {
+			fmt.Fprintf(os.Stderr, "\n%d of %d repositories failed, rolling back all repositories\n", failed, len(targetDirs))
+		}
+		rollback := runRepos(context.Background(), targetDirs, jobs, func(ctx context.Context, dir string, log *repoLog) (string, error) {
+			return rollbackWorktree(log, dir, created[dir])
+		})
+		if len(rollback) > 1 {
+			printSummaryTable(rollback)
+		}
+		removeEmptyFolderDir(ws.folderDir(folderName))
+		if failed := countFailed(rollback); failed > 0 {
+			fmt.Fprintf(os.Stderr, "Rollback failed in %d repositories, see above\n", failed)
+		} else {
+			fmt.Fprintln(textOut, "Rolled back. Config left unchanged.")
+		}
+		emitResult(true)
+		if ctx.Err() != nil {
+			return exitInterrupted
+		}
+		return exitFailure
+	}
+	// Record which repos now belong to the folder
+	var records []RepoRecord
+	for _, r := range results {
+		if r.Err == nil {
+			result := created[r.Dir]
+			records = append(records, RepoRecord{
+				Name:    filepath.Base(r.Dir),
+				Dir:     r.Dir,
+				Path:    result.Path,
+				Branch:  result.Branch,
+				BaseRef: result.BaseRef,
+			})
+		}
+	}
+
+	// The mapping is only saved once the folder has a worktree (in atomic mode, all of them)
+	if len(records) == 0 {
+		removeEmptyFolderDir(ws.folderDir(folderName))
+		fmt.Fprintln(os.Stderr, "No worktrees were created. Config left unchanged.")
+		emitResult(false)
+		return exitCodeFor(ctx, failed)
+	}
+	touchFolder(config, folderName, spec)
+	recordRepos(config, folderName, records)
+	if ws.save() && *folderFlag != "" {
+		fmt.Fprintf(textOut, "Saved mapping: folder '%s' -> branch '%s'\n", folderName, spec)
+	}
+
+	// Symlink root directory files to folder directory after creating worktrees
+	if err := symlinkRootFiles(ws.cwd, ws.folderDir(folderName), targetDirs); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to symlink some root files: %v\n", err)
+	}
+
+	emitResult(false)
+	return exitCodeFor(ctx, failed)
+}
+
+// exitCodeFor picks the exit code for a run over several repos
+func exitCodeFor(ctx context.Context, failed int) int {
+	switch {
+	case ctx.Err() != nil:
+		return exitInterrupted
+	case failed > 0:
+		return exitFailure
+	default:
+		return exitOK
+	}
+}
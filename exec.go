@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+func runExec(args []string) int {
+	fs := newFlagSet("exec")
+	failFastFlag := fs.Bool("fail-fast", false, "Stop starting the command in more repos after the first failure")
+	jobsFlag := fs.Int("jobs", 0, "Number of repos to run in parallel (default 4, or jobs in config; 1 runs them one by one with streaming output)")
+	formatFlag := addFormatFlag(fs)
+
+	args, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(args) < 2 {
+		return usageError(fs, "expected a folder name and a command after --")
+	}
+	folderName, command := args[0], args[1:]
+
+	out, err := setupOutput(*formatFlag)
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+	target, err := loadFolderTarget(ws, folderName)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	fmt.Fprintf(textOut, "Running in %d worktrees of folder '%s': %s\n", len(target.dirs), folderName, describeCommand(command))
+
+	// Ctrl-C cancels repos that haven't started yet; with -fail-fast so does the first failure
+	ctx, stop := interruptContext()
+	defer stop()
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	startedAt := time.Now()
+
+	var once sync.Once
+	results := runRepos(runCtx, target.dirs, resolveJobs(ws.config, *jobsFlag), func(ctx context.Context, dir string, log *repoLog) (string, error) {
+		repo := target.records[dir]
+		detail, err := execInWorktree(log, repo, folderName, target.info.repoBranch(repo), command)
+		if err != nil && *failFastFlag {
+			once.Do(func() {
+				log.Printf("Failed, not starting the remaining repos (-fail-fast)")
+				cancel()
+			})
+		}
+		if out != nil && out.stream {
+			out.write(line("repo_result", toJSONRepoResult(repoResult{Dir: dir, Detail: detail, Err: err}, repo.Path, "")))
+		}
+		return detail, err
+	})
+	printSummaryTable(results)
+
+	// Repos that -fail-fast or Ctrl-C kept from starting didn't fail themselves
+	failed, notRun := countFailed(results), 0
+	for _, r := range results {
+		if errors.Is(r.Err, errCancelled) {
+			notRun++
+		}
+	}
+	failed -= notRun
+	switch {
+	case failed > 0 && notRun > 0:
+		fmt.Fprintf(os.Stderr, "\nFailed in %d of %d repositories, %d not run\n", failed, len(results), notRun)
+	case failed > 0:
+		fmt.Fprintf(os.Stderr, "\nFailed in %d of %d repositories\n", failed, len(results))
+	case notRun > 0:
+		fmt.Fprintf(os.Stderr, "\nNot run in %d of %d repositories\n", notRun, len(results))
+	default:
+		fmt.Fprintf(textOut, "\nSucceeded in all %d repositories\n", len(results))
+	}
+
+	if out != nil {
+		doc := newRunResult("exec", folderName, target.info.Branch, startedAt, results, false)
+		for i, r := range results {
+			doc.Repos[i].Path = target.records[r.Dir].Path
+		}
+		out.write(doc)
+	}
+
+	return exitCodeFor(ctx, failed+notRun)
+}
+
+// execInWorktree runs a command in a repo's worktree, prefixing its output with the repo
+// name. The command learns where it runs from WORKTREE_PLUS_* environment variables.
+func execInWorktree(log *repoLog, repo RepoRecord, folderName, branchName string, command []string) (string, error) {
+	if _, err := os.Stat(repo.Path); err != nil {
+		return "", fmt.Errorf("worktree does not exist at %s", repo.Path)
+	}
+
+	cmd := commandFor(command)
+	cmd.Dir = repo.Path
+	cmd.Env = append(os.Environ(),
+		"WORKTREE_PLUS_FOLDER="+folderName,
+		"WORKTREE_PLUS_REPO="+repo.Name,
+		"WORKTREE_PLUS_BRANCH="+branchName,
+	)
+	stdout, stderr := log.PrefixedStdout(), log.PrefixedStderr()
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+	elapsed := time.Since(start).Round(100 * time.Millisecond)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return "", fmt.Errorf("exit %d after %s", exitErr.ExitCode(), elapsed)
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("exit 0 after %s", elapsed), nil
+}
+
+// commandFor runs a single argument through the shell, so that pipes and variables work
+// when the command is quoted, e.g. exec api -- 'make test | tail'. Several arguments are
+// the program and its arguments, run as given.
+func commandFor(argv []string) *exec.Cmd {
+	if len(argv) == 1 {
+		return shellCommand(argv[0])
+	}
+	return exec.Command(argv[0], argv[1:]...)
+}
+
+// describeCommand shows a command as it could be typed into a shell
+func describeCommand(argv []string) string {
+	if len(argv) == 1 {
+		return argv[0]
+	}
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote quotes an argument for sh when it contains anything but plain characters
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:,+@%") == "" {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// shellCommand runs command through the platform's shell
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fetchAll fetches the configured remotes in every directory concurrently and reports
+// the result per repo. Returns the number of repos that failed to fetch.
+func fetchAll(config *Config, dirs []string, timeout time.Duration) int {
+	fmt.Fprintf(textOut, "Fetching %d repositories...\n", len(dirs))
+
+	errs := make([]error, len(dirs))
+	var wg sync.WaitGroup
+	for i, dir := range dirs {
+		wg.Add(1)
+		go func(i int, dir string) {
+			defer wg.Done()
+			errs[i] = fetchRemotes(dir, resolveRemotes(config, filepath.Base(dir)), timeout)
+		}(i, dir)
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, dir := range dirs {
+		dirName := filepath.Base(dir)
+		if errs[i] != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "[%s] Fetch failed: %v\n", dirName, errs[i])
+		} else {
+			fmt.Fprintf(textOut, "[%s] Fetched\n", dirName)
+		}
+	}
+
+	return failed
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 )
@@ -41,3 +42,53 @@ func findGitDirs(root string) ([]string, error) {
 
 	return gitDirs, nil
 }
+
+// folderRepos returns the repos that belong to a folder. Folders recorded before repo
+// lists were stored fall back to the git directories in root at their default paths.
+func folderRepos(config *Config, root, folderName string) ([]RepoRecord, error) {
+	if info := config.Folders[folderName]; info != nil && len(info.Repos) > 0 {
+		return info.Repos, nil
+	}
+
+	dirs, err := findGitDirs(root)
+	if err != nil {
+		return nil, err
+	}
+	repos := make([]RepoRecord, len(dirs))
+	for i, dir := range dirs {
+		repos[i] = RepoRecord{Name: filepath.Base(dir), Dir: dir, Path: getWorktreePath(dir, folderName)}
+	}
+	return repos, nil
+}
+
+// folderTarget is a folder's worktrees resolved for a command that works in each of them
+type folderTarget struct {
+	info    *FolderInfo
+	dirs    []string
+	records map[string]RepoRecord // keyed by main checkout directory
+}
+
+// loadFolderTarget looks up an active folder and the repos that belong to it
+func loadFolderTarget(ws *workspace, folderName string) (*folderTarget, error) {
+	info := ws.config.Folders[folderName]
+	if info == nil {
+		return nil, fmt.Errorf("unknown folder '%s'", folderName)
+	}
+	if !info.IsActive {
+		return nil, fmt.Errorf("folder '%s' is not active", folderName)
+	}
+	repos, err := folderRepos(ws.config, ws.cwd, folderName)
+	if err != nil {
+		return nil, fmt.Errorf("finding git directories: %w", err)
+	}
+	if len(repos) == 0 {
+		return nil, fmt.Errorf("no repos recorded for folder '%s'", folderName)
+	}
+
+	target := &folderTarget{info: info, records: make(map[string]RepoRecord)}
+	for _, repo := range repos {
+		target.dirs = append(target.dirs, repo.Dir)
+		target.records[repo.Dir] = repo
+	}
+	return target, nil
+}
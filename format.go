@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Machine-readable output
+//
+// With -format=json a command writes a single JSON document to stdout; with
+// -format=ndjson it writes one JSON object per line as results become available.
+// All human-readable progress goes to stderr instead. Every object carries
+// "schema_version" and "kind" so consumers can dispatch on them. The schema only
+// changes in backwards compatible ways (new fields) without bumping the version.
+//
+// Kinds:
+//
+//	list          (json)   {"folders": [folder...]}
+//	folder        (ndjson) one folder per line
+//	status        (json)   {"folders": [folder_status...], "main": [repo_status...]}
+//	folder_status (ndjson) one folder per line
+//	main_status   (ndjson) the main checkouts, when requested
+//	create/remove (json)   {"folder", "branch", "ok", "started_at", "finished_at", "repos": [repo_result...]}
+//	                       remove adds "trash_id" when files were moved to the trash
+//	reopen/switch (json)   same fields as create
+//	exec          (json)   same fields as create, detail holds each repo's exit status
+//	commit/push   (json)   same fields as create
+//	sync          (json)   same fields as create
+//	rename-branch (json)   same fields as create, including "rolled_back"
+//	rename-folder (json)   same fields as create, folder is the new name
+//	repo_result   (ndjson) one line per repo as it finishes, followed by the run's summary
+//
+// A command that stops before producing its result, on bad flags or arguments, on an
+// error, or with nothing to do, writes {"ok", "error"} instead, with the command's name
+// as the kind. "error" is set whenever "ok" is false.
+//
+// folder:        folder, branch, branches, active, created_at, last_used, repos: [{repo, dir, path, branch, base_ref}]
+//
+//	branches maps repo names ("*" for the rest) to branches when they differ per repo
+//
+// folder_status: folder, branch, active, repos: [repo_status...]
+// repo_status:   repo, path, present, branch, expected_branch, branch_mismatch, head, dirty,
+//
+//	upstream, upstream_ahead, upstream_behind, base_ref, base_ahead, base_behind, error
+//
+// repo_result:   repo, dir, path, ok, detail, base_ref, error
+//
+// Timestamps are RFC 3339. Optional string fields are omitted when empty.
+const schemaVersion = 1
+
+// Output formats accepted by -format
+const (
+	formatText   = "text"
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+)
+
+// parseFormat validates the -format flag value
+func parseFormat(value string) (string, error) {
+	switch value {
+	case "", formatText:
+		return formatText, nil
+	case formatJSON, formatNDJSON:
+		return value, nil
+	default:
+		return "", fmt.Errorf("unknown format '%s' (expected text, json or ndjson)", value)
+	}
+}
+
+// jsonWriter writes schema-versioned objects to the machine-readable output
+type jsonWriter struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	stream bool // ndjson: emit records as they are produced instead of one document
+	wrote  bool // something was written, so the command needs no outcome object
+}
+
+// newJSONWriter returns a writer for the given format, or nil for text output
+func newJSONWriter(w io.Writer, format string) *jsonWriter {
+	if format == formatText {
+		return nil
+	}
+	enc := json.NewEncoder(w)
+	if format == formatJSON {
+		enc.SetIndent("", "  ")
+	}
+	return &jsonWriter{enc: enc, stream: format == formatNDJSON}
+}
+
+// write encodes v, which should embed jsonHeader
+func (w *jsonWriter) write(v any) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.wrote = true
+	if err := w.enc.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+	}
+}
+
+// jsonHeader starts every object
+type jsonHeader struct {
+	SchemaVersion int    `json:"schema_version"`
+	Kind          string `json:"kind"`
+}
+
+func header(kind string) jsonHeader {
+	return jsonHeader{SchemaVersion: schemaVersion, Kind: kind}
+}
+
+// jsonLine is a single ndjson record: a header followed by the fields of one element
+type jsonLine[T any] struct {
+	jsonHeader
+	Element T
+}
+
+// MarshalJSON flattens the element's fields next to the header
+func (l jsonLine[T]) MarshalJSON() ([]byte, error) {
+	head, err := json.Marshal(l.jsonHeader)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(l.Element)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) <= 2 {
+		return head, nil
+	}
+	// Splice {"schema_version":..,"kind":..} and {...} into one object
+	return append(append(head[:len(head)-1], ','), body[1:]...), nil
+}
+
+// line wraps an element with a header for ndjson output
+func line[T any](kind string, element T) jsonLine[T] {
+	return jsonLine[T]{jsonHeader: header(kind), Element: element}
+}
+
+type jsonRepo struct {
+	Repo    string `json:"repo"`
+	Dir     string `json:"dir"`
+	Path    string `json:"path"`
+	Branch  string `json:"branch,omitempty"`
+	BaseRef string `json:"base_ref,omitempty"`
+}
+
+type jsonFolder struct {
+	Folder    string            `json:"folder"`
+	Branch    string            `json:"branch"`
+	Branches  map[string]string `json:"branches,omitempty"`
+	Active    bool              `json:"active"`
+	CreatedAt time.Time         `json:"created_at,omitzero"`
+	LastUsed  time.Time         `json:"last_used"`
+	Repos     []jsonRepo        `json:"repos"`
+}
+
+type jsonList struct {
+	jsonHeader
+	Folders []jsonFolder `json:"folders"`
+}
+
+type jsonRepoStatus struct {
+	Repo           string `json:"repo"`
+	Path           string `json:"path"`
+	Present        bool   `json:"present"`
+	Branch         string `json:"branch,omitempty"`
+	ExpectedBranch string `json:"expected_branch,omitempty"`
+	BranchMismatch bool   `json:"branch_mismatch"`
+	Head           string `json:"head,omitempty"`
+	Dirty          int    `json:"dirty"`
+	Upstream       string `json:"upstream,omitempty"`
+	UpstreamAhead  int    `json:"upstream_ahead"`
+	UpstreamBehind int    `json:"upstream_behind"`
+	BaseRef        string `json:"base_ref,omitempty"`
+	BaseAhead      int    `json:"base_ahead"`
+	BaseBehind     int    `json:"base_behind"`
+	Error          string `json:"error,omitempty"`
+}
+
+type jsonFolderStatus struct {
+	Folder string           `json:"folder"`
+	Branch string           `json:"branch"`
+	Active bool             `json:"active"`
+	Repos  []jsonRepoStatus `json:"repos"`
+}
+
+type jsonMainStatus struct {
+	jsonHeader
+	Repos []jsonRepoStatus `json:"repos"`
+}
+
+type jsonStatus struct {
+	jsonHeader
+	Folders []jsonFolderStatus `json:"folders"`
+	Main    []jsonRepoStatus   `json:"main,omitempty"`
+}
+
+type jsonRepoResult struct {
+	Repo    string `json:"repo"`
+	Dir     string `json:"dir"`
+	Path    string `json:"path,omitempty"`
+	OK      bool   `json:"ok"`
+	Detail  string `json:"detail,omitempty"`
+	BaseRef string `json:"base_ref,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type jsonOutcome struct {
+	jsonHeader
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type jsonRunResult struct {
+	jsonHeader
+	Folder     string           `json:"folder"`
+	Branch     string           `json:"branch"`
+	OK         bool             `json:"ok"`
+	RolledBack bool             `json:"rolled_back,omitempty"`
+	TrashID    string           `json:"trash_id,omitempty"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+	Repos      []jsonRepoResult `json:"repos"`
+}
+
+func toJSONFolder(f FolderHistory) jsonFolder {
+	folder := jsonFolder{
+		Folder:    f.Name,
+		Branch:    f.Branch,
+		Branches:  f.Branches,
+		Active:    f.IsActive,
+		CreatedAt: f.CreatedAt,
+		LastUsed:  f.LastUsed,
+		Repos:     []jsonRepo{},
+	}
+	for _, r := range f.Repos {
+		folder.Repos = append(folder.Repos, jsonRepo{Repo: r.Name, Dir: r.Dir, Path: r.Path, Branch: r.Branch, BaseRef: r.BaseRef})
+	}
+	return folder
+}
+
+func toJSONRepoStatus(s repoStatus) jsonRepoStatus {
+	status := jsonRepoStatus{
+		Repo:           s.Name,
+		Path:           s.Path,
+		Present:        s.Present,
+		Branch:         s.Branch,
+		ExpectedBranch: s.ExpectedBranch,
+		BranchMismatch: s.branchMismatch(),
+		Head:           s.Head,
+		Dirty:          s.Dirty,
+		Upstream:       s.Upstream,
+		UpstreamAhead:  s.UpstreamAhead,
+		UpstreamBehind: s.UpstreamBehind,
+		BaseRef:        s.BaseRef,
+		BaseAhead:      s.BaseAhead,
+		BaseBehind:     s.BaseBehind,
+	}
+	if s.Err != nil {
+		status.Error = s.Err.Error()
+	}
+	return status
+}
+
+func toJSONRepoStatuses(repos []repoStatus) []jsonRepoStatus {
+	out := []jsonRepoStatus{}
+	for _, r := range repos {
+		out = append(out, toJSONRepoStatus(r))
+	}
+	return out
+}
+
+func toJSONFolderStatus(s folderStatus) jsonFolderStatus {
+	return jsonFolderStatus{
+		Folder: s.Name,
+		Branch: s.Branch,
+		Active: s.IsActive,
+		Repos:  toJSONRepoStatuses(s.Repos),
+	}
+}
+
+func toJSONRepoResult(r repoResult, path, baseRef string) jsonRepoResult {
+	result := jsonRepoResult{
+		Repo:    filepath.Base(r.Dir),
+		Dir:     r.Dir,
+		Path:    path,
+		OK:      r.Err == nil,
+		Detail:  r.Detail,
+		BaseRef: baseRef,
+	}
+	if r.Err != nil {
+		result.Error = r.Err.Error()
+	}
+	return result
+}
+
+// newRunResult builds the summary document of a create or remove run. Callers fill
+// in each repo's path and base ref.
+func newRunResult(kind, folder, branch string, startedAt time.Time, results []repoResult, rolledBack bool) jsonRunResult {
+	doc := jsonRunResult{
+		jsonHeader: header(kind),
+		Folder:     folder,
+		Branch:     branch,
+		OK:         countFailed(results) == 0 && !rolledBack,
+		RolledBack: rolledBack,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+		Repos:      []jsonRepoResult{},
+	}
+	for _, r := range results {
+		doc.Repos = append(doc.Repos, toJSONRepoResult(r, "", ""))
+	}
+	return doc
+}
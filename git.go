@@ -1,13 +1,37 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+// defaultFetchTimeout bounds how long a single git fetch may run
+const defaultFetchTimeout = 60 * time.Second
+
+// defaultRemoteTimeout bounds how long a single git ls-remote may run
+const defaultRemoteTimeout = 15 * time.Second
+
+// errTimeout is wrapped by runGitTimeout when a command is killed for running too long
+var errTimeout = errors.New("timed out")
+
+// remoteLookup controls how findRemoteBranch checks remotes for a branch
+type remoteLookup struct {
+	Offline bool          // only consult locally known refs/remotes/*
+	Timeout time.Duration // limit for each ls-remote before falling back to local refs
+}
+
+// remoteMatch describes where findRemoteBranch found a branch
+type remoteMatch struct {
+	Remote string
+	Local  bool // found in refs/remotes without asking the remote, so there is nothing to fetch
+}
+
 // branchExists checks if a branch exists in the repository
 func branchExists(repoDir, branchName string) bool {
 	cmd := exec.Command("git", "show-ref", "--verify", "--quiet", "refs/heads/"+branchName)
@@ -16,15 +40,330 @@ func branchExists(repoDir, branchName string) bool {
 	return err == nil
 }
 
-// remoteBranchExists checks if a remote branch exists
-func remoteBranchExists(repoDir, branchName string) bool {
-	cmd := exec.Command("git", "ls-remote", "--heads", "origin", branchName)
+// listRemotes returns the names of the remotes configured in the repository
+func listRemotes(repoDir string) []string {
+	cmd := exec.Command("git", "remote")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(output))
+}
+
+// findRemoteBranch returns the first remote, in search order, that has the branch.
+// Remotes that aren't configured in the repository are skipped. When offline, or when
+// a remote doesn't answer in time, the locally known refs/remotes/* are used instead;
+// the returned warnings describe any such fallback.
+func findRemoteBranch(repoDir string, remotes []string, branchName string, lookup remoteLookup) (remoteMatch, bool, []string) {
+	known := make(map[string]bool)
+	for _, r := range listRemotes(repoDir) {
+		known[r] = true
+	}
+
+	var warnings []string
+	for _, remote := range remotes {
+		if !known[remote] {
+			continue
+		}
+		if lookup.Offline {
+			if remoteTrackingBranchExists(repoDir, remote, branchName) {
+				return remoteMatch{Remote: remote, Local: true}, true, warnings
+			}
+			continue
+		}
+		exists, err := remoteBranchExists(repoDir, remote, branchName, lookup.Timeout)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%v, using local refs for '%s'", err, remote))
+			if remoteTrackingBranchExists(repoDir, remote, branchName) {
+				return remoteMatch{Remote: remote, Local: true}, true, warnings
+			}
+			continue
+		}
+		if exists {
+			return remoteMatch{Remote: remote}, true, warnings
+		}
+	}
+	return remoteMatch{}, false, warnings
+}
+
+// remoteBranchExists asks the remote whether it has the branch
+func remoteBranchExists(repoDir, remote, branchName string, timeout time.Duration) (bool, error) {
+	output, err := runGitTimeout(repoDir, timeout, "ls-remote", "--heads", remote, branchName)
+	if err != nil {
+		return false, err
+	}
+	return len(output) > 0, nil
+}
+
+// remoteTrackingBranchExists checks for a locally known refs/remotes/<remote>/<branch>
+func remoteTrackingBranchExists(repoDir, remote, branchName string) bool {
+	cmd := exec.Command("git", "show-ref", "--verify", "--quiet", "refs/remotes/"+remote+"/"+branchName)
+	cmd.Dir = repoDir
+	return cmd.Run() == nil
+}
+
+// runGitTimeout runs a git command that talks to a remote, killing it after the timeout.
+// Terminal prompts are disabled so a missing credential fails instead of hanging.
+func runGitTimeout(repoDir string, timeout time.Duration, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = repoDir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	output, err := cmd.CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return output, fmt.Errorf("git %s %w after %s", args[0], errTimeout, timeout)
+	}
+	if err != nil {
+		msg := strings.TrimSpace(string(output))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return output, fmt.Errorf("git %s failed: %s", args[0], msg)
+	}
+	return output, nil
+}
+
+// fetchBranch fetches a single branch so refs/remotes/<remote>/<branch> exists
+func fetchBranch(repoDir, remote, branchName string, timeout time.Duration) error {
+	refspec := fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", branchName, remote, branchName)
+	_, err := runGitTimeout(repoDir, timeout, "fetch", "--no-tags", remote, refspec)
+	return err
+}
+
+// fetchRemotes fetches all branches from the given remotes
+func fetchRemotes(repoDir string, remotes []string, timeout time.Duration) error {
+	args := append([]string{"fetch", "--prune", "--multiple"}, remotes...)
+	_, err := runGitTimeout(repoDir, timeout, args...)
+	return err
+}
+
+// resolveCommit resolves a ref to its abbreviated commit hash
+func resolveCommit(repoDir, ref string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "--short", ref+"^{commit}")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("ref '%s' not found", ref)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// currentBranch returns the branch checked out in the repo, or "" if HEAD is detached
+func currentBranch(repoDir string) string {
+	cmd := exec.Command("git", "symbolic-ref", "--quiet", "--short", "HEAD")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// headCommit returns the abbreviated commit checked out in the repo
+func headCommit(repoDir string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--short", "HEAD")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse failed: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// changedFiles returns the modified, staged and untracked paths in the worktree
+func changedFiles(repoDir string) ([]string, error) {
+	cmd := exec.Command("git", "status", "--porcelain")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git status failed: %w", err)
+	}
+
+	var files []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if len(line) > 3 {
+			files = append(files, line[3:])
+		}
+	}
+	return files, nil
+}
+
+// dirtyFiles returns the files in the worktree that differ from HEAD or are untracked,
+// as slash-separated paths. Deleted files are left out since there is nothing to keep.
+func dirtyFiles(repoDir string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	for _, args := range [][]string{
+		{"diff", "--name-only", "-z", "HEAD"},
+		{"ls-files", "-z", "--others", "--exclude-standard"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repoDir
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("git %s failed: %w", args[0], err)
+		}
+		for _, file := range strings.Split(string(output), "\x00") {
+			if file == "" || seen[file] {
+				continue
+			}
+			seen[file] = true
+			if _, err := os.Lstat(filepath.Join(repoDir, filepath.FromSlash(file))); err == nil {
+				files = append(files, file)
+			}
+		}
+	}
+	return files, nil
+}
+
+// worktreeOf returns the path of the worktree that has the branch checked out, or "" if
+// none has
+func worktreeOf(repoDir, branchName string) string {
+	cmd := exec.Command("git", "worktree", "list", "--porcelain")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	var path string
+	for _, line := range strings.Split(string(output), "\n") {
+		if p, ok := strings.CutPrefix(line, "worktree "); ok {
+			path = p
+		} else if line == "branch refs/heads/"+branchName {
+			return path
+		}
+	}
+	return ""
+}
+
+// upstreamBranch returns the upstream of the checked out branch, or "" if it has none
+func upstreamBranch(repoDir string) string {
+	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{upstream}")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// upstreamOf returns the upstream of a local branch, or "" if it has none
+func upstreamOf(repoDir, branch string) string {
+	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "--symbolic-full-name", branch+"@{upstream}")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// upstreamRemote returns the remote a local branch's upstream is on, or "" if it has none
+func upstreamRemote(repoDir, branch string) string {
+	cmd := exec.Command("git", "config", "--get", "branch."+branch+".remote")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// unmergedFiles returns the paths with unresolved conflicts
+func unmergedFiles(repoDir string) ([]string, error) {
+	cmd := exec.Command("git", "diff", "--name-only", "--diff-filter=U")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff failed: %w", err)
+	}
+	var files []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// rebaseInProgress reports whether the worktree is in the middle of a rebase
+func rebaseInProgress(repoDir string) bool {
+	for _, name := range []string{"rebase-merge", "rebase-apply"} {
+		cmd := exec.Command("git", "rev-parse", "--path-format=absolute", "--git-path", name)
+		cmd.Dir = repoDir
+		output, err := cmd.Output()
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(strings.TrimSpace(string(output))); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeInProgress reports whether the worktree is in the middle of a merge
+func mergeInProgress(repoDir string) bool {
+	cmd := exec.Command("git", "rev-parse", "--quiet", "--verify", "MERGE_HEAD")
+	cmd.Dir = repoDir
+	return cmd.Run() == nil
+}
+
+// isMergedInto reports whether every commit of branch is reachable from ref
+func isMergedInto(repoDir, branch, ref string) bool {
+	cmd := exec.Command("git", "merge-base", "--is-ancestor", "refs/heads/"+branch, ref)
+	cmd.Dir = repoDir
+	return cmd.Run() == nil
+}
+
+// upstreamGone reports whether branch tracks a remote branch that no longer exists,
+// typically because it was merged and deleted and the remote was fetched with --prune
+func upstreamGone(repoDir, branch string) bool {
+	cmd := exec.Command("git", "for-each-ref", "--format=%(upstream:track)", "refs/heads/"+branch)
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(output)) == "[gone]"
+}
+
+// branchUnchanged reports whether branch still points where it was created, going by
+// its reflog. Returns false when the reflog doesn't go back that far.
+func branchUnchanged(repoDir, branch string) bool {
+	cmd := exec.Command("git", "reflog", "show", "--format=%H", "refs/heads/"+branch)
 	cmd.Dir = repoDir
 	output, err := cmd.Output()
 	if err != nil {
 		return false
 	}
-	return len(output) > 0
+	entries := strings.Fields(string(output))
+	if len(entries) == 0 {
+		return false
+	}
+	for _, entry := range entries {
+		if entry != entries[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// aheadBehind counts the commits in HEAD that aren't in ref, and in ref that aren't in HEAD
+func aheadBehind(repoDir, ref string) (ahead, behind int, err error) {
+	cmd := exec.Command("git", "rev-list", "--left-right", "--count", "HEAD..."+ref)
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot compare with '%s'", ref)
+	}
+	if _, err := fmt.Sscanf(string(output), "%d %d", &ahead, &behind); err != nil {
+		return 0, 0, fmt.Errorf("unexpected rev-list output: %q", output)
+	}
+	return ahead, behind, nil
 }
 
 // getIgnoredItems returns a list of gitignored files and directories in the repo
@@ -51,14 +390,27 @@ func getIgnoredItems(repoDir string) ([]string, error) {
 	return items, nil
 }
 
-// addToGitExclude adds items to the worktree's .gitignore and marks it as assume-unchanged
-func addToGitExclude(worktreeDir string, items []string) error {
-	gitignorePath := filepath.Join(worktreeDir, ".gitignore")
+// excludeHeader starts the patterns worktree_plus adds for the shared symlinks
+const excludeHeader = "# worktree_plus symlinks"
+
+// addToGitExclude hides items from git in this worktree only. They go into the exclude
+// file in the worktree's own git dir, which a worktree-scoped core.excludesFile points
+// to, so neither the tracked .gitignore nor the other worktrees are touched. Since that
+// setting replaces the user's global excludes file, the file starts with a copy of it.
+// The copy is made once; later edits to the global file only apply here after
+// migrate-excludes -refresh-global.
+func addToGitExclude(log *repoLog, worktreeDir string, items []string) error {
+	gitDir, err := worktreeGitDir(worktreeDir)
+	if err != nil {
+		return err
+	}
+	excludePath := filepath.Join(gitDir, "info", "exclude")
 
-	// Read existing .gitignore if it exists
 	var existingContent string
-	if data, err := os.ReadFile(gitignorePath); err == nil {
+	if data, err := os.ReadFile(excludePath); err == nil {
 		existingContent = string(data)
+	} else {
+		existingContent = globalExcludesCopy(worktreeDir, excludePath)
 	}
 
 	// Build set of existing patterns to avoid duplicates
@@ -80,38 +432,126 @@ func addToGitExclude(worktreeDir string, items []string) error {
 		}
 	}
 
-	if len(newItems) == 0 {
-		return nil // Nothing new to add
+	if len(newItems) > 0 {
+		content := existingContent
+		if !strings.Contains(content, excludeHeader) {
+			content += "\n" + excludeHeader + "\n"
+		}
+		content += strings.Join(newItems, "\n") + "\n"
+		if err := os.MkdirAll(filepath.Dir(excludePath), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(excludePath, []byte(content), 0644); err != nil {
+			return fmt.Errorf("cannot write %s: %w", excludePath, err)
+		}
+	}
+
+	// Per-worktree settings need the extension, which changes the repository format once:
+	// git versions before 2.20 can no longer open it
+	var commands [][]string
+	if !gitConfigIs(worktreeDir, "extensions.worktreeConfig", "true") {
+		log.Printf("Enabling extensions.worktreeConfig in the repository, for per-worktree excludes")
+		commands = append(commands, []string{"config", "extensions.worktreeConfig", "true"})
+	}
+	commands = append(commands, []string{"config", "--worktree", "core.excludesFile", excludePath})
+	for _, args := range commands {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = worktreeDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s failed: %s", strings.Join(args, " "), strings.TrimSpace(string(output)))
+		}
 	}
 
-	// Append to .gitignore
-	f, err := os.OpenFile(gitignorePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	return nil
+}
+
+// refreshGlobalExcludes replaces the copy of the global excludes file at the top of a
+// worktree's exclude file with the global file's current contents. Worktrees without an
+// exclude file of their own are left alone. Returns whether the file changed.
+func refreshGlobalExcludes(worktreeDir string, dryRun bool) (bool, error) {
+	gitDir, err := worktreeGitDir(worktreeDir)
 	if err != nil {
-		return fmt.Errorf("cannot open .gitignore: %w", err)
+		return false, err
+	}
+	excludePath := filepath.Join(gitDir, "info", "exclude")
+	data, err := os.ReadFile(excludePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
 	}
 
-	// Add a header comment if this is a new section
-	if !strings.Contains(existingContent, "# worktree_plus symlinks") {
-		if _, err := f.WriteString("\n# worktree_plus symlinks\n"); err != nil {
-			f.Close()
-			return err
+	// The copy, if one was made, runs up to our section
+	content, rest := string(data), string(data)
+	if strings.HasPrefix(content, excludesCopiedFrom) {
+		rest = ""
+		if i := strings.Index(content, "\n"+excludeHeader); i >= 0 {
+			rest = content[i:]
 		}
 	}
+	refreshed := globalExcludesCopy(worktreeDir, excludePath) + rest
+	if refreshed == content || dryRun {
+		return refreshed != content, nil
+	}
+	if err := os.WriteFile(excludePath, []byte(refreshed), 0644); err != nil {
+		return false, fmt.Errorf("cannot write %s: %w", excludePath, err)
+	}
+	return true, nil
+}
 
-	for _, item := range newItems {
-		if _, err := f.WriteString(item + "\n"); err != nil {
-			f.Close()
-			return err
-		}
+// excludesCopiedFrom starts the copy of the global excludes file in a worktree's exclude file
+const excludesCopiedFrom = "# copied from "
+
+// globalExcludesCopy returns the global excludes file with a line saying where it came
+// from, or "" if there is none
+func globalExcludesCopy(repoDir, excludePath string) string {
+	global := globalExcludesFile(repoDir)
+	if global == "" || global == excludePath {
+		return ""
 	}
-	f.Close()
+	data, err := os.ReadFile(global)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s%s\n%s\n", excludesCopiedFrom, global, strings.TrimRight(string(data), "\n"))
+}
 
-	// Mark .gitignore as assume-unchanged so git ignores our modifications
-	cmd := exec.Command("git", "update-index", "--assume-unchanged", "--", ".gitignore")
+// gitConfigIs reports whether a git config key is set to value in the repository
+func gitConfigIs(repoDir, key, value string) bool {
+	cmd := exec.Command("git", "config", "--get", key)
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	return err == nil && strings.TrimSpace(string(output)) == value
+}
+
+// worktreeGitDir returns the absolute git dir of a worktree, .git/worktrees/<name> for
+// a linked one
+func worktreeGitDir(worktreeDir string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--absolute-git-dir")
 	cmd.Dir = worktreeDir
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to set assume-unchanged on .gitignore: %w", err)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("cannot find the git dir of %s", worktreeDir)
 	}
+	return strings.TrimSpace(string(output)), nil
+}
 
-	return nil
+// globalExcludesFile returns the excludes file git uses in the repo when no worktree
+// overrides it: core.excludesFile from the repository, user or system config, or the XDG
+// default
+func globalExcludesFile(repoDir string) string {
+	for _, scope := range []string{"--local", "--global", "--system"} {
+		cmd := exec.Command("git", "config", scope, "--path", "core.excludesFile")
+		cmd.Dir = repoDir
+		if output, err := cmd.Output(); err == nil {
+			return strings.TrimSpace(string(output))
+		}
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "ignore")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "git", "ignore")
+	}
+	return ""
 }
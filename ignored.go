@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// What a new worktree gets for a gitignored item of the main checkout
+const (
+	ignoredShare   = "share"   // a symlink to the main checkout's item
+	ignoredCopy    = "copy"    // a copy of its own, reflinked or hardlinked where possible
+	ignoredReflink = "reflink" // same as copy, which tries a copy-on-write reflink first
+	ignoredSkip    = "skip"    // nothing
+	ignoredFresh   = "fresh"   // an empty directory
+)
+
+// ignoredActions lists the valid actions for ignored rules
+var ignoredActions = []string{ignoredShare, ignoredCopy, ignoredReflink, ignoredSkip, ignoredFresh}
+
+// ignoredRule returns the first rule matching item, or one that shares it when none does
+func ignoredRule(rules []IgnoredRule, item string) IgnoredRule {
+	item = filepath.ToSlash(item)
+	for _, rule := range rules {
+		if ok, _ := path.Match(rule.Pattern, item); ok {
+			return rule
+		}
+		if ok, _ := path.Match(rule.Pattern, path.Base(item)); ok {
+			return rule
+		}
+	}
+	return IgnoredRule{Pattern: item, Action: ignoredShare}
+}
+
+// setupIgnoredItems gives the worktree the gitignored items of the source checkout, each
+// shared, copied, skipped or started fresh as the rules say
+func setupIgnoredItems(log *repoLog, sourceDir, worktreeDir string, rules []IgnoredRule) error {
+	ignoredItems, err := getIgnoredItems(sourceDir)
+	if err != nil {
+		return err
+	}
+
+	if len(ignoredItems) == 0 {
+		log.Printf("No gitignored items to set up")
+		return nil
+	}
+
+	log.Printf("Setting up %d gitignored items...", len(ignoredItems))
+
+	var errors []string
+	var linkedItems []string
+	counts := make(map[string]int)
+
+	for _, item := range ignoredItems {
+		sourcePath := filepath.Join(sourceDir, item)
+		targetPath := filepath.Join(worktreeDir, item)
+
+		// Check if source exists
+		sourceInfo, err := os.Stat(sourcePath)
+		if err != nil {
+			continue // Skip if source doesn't exist
+		}
+
+		// Check if target already exists
+		if _, err := os.Lstat(targetPath); err == nil {
+			continue // Skip if target already exists
+		}
+
+		rule := ignoredRule(rules, item)
+		action := rule.Action
+		if action == ignoredSkip {
+			counts[action]++
+			log.Printf("  %s: %s", action, item)
+			continue
+		}
+		if action == ignoredFresh && !sourceInfo.IsDir() {
+			counts[ignoredSkip]++
+			log.Printf("  %s: %s (not a directory, skipped)", action, item)
+			continue
+		}
+
+		// Create parent directories in worktree if needed
+		targetParent := filepath.Dir(targetPath)
+		if err := os.MkdirAll(targetParent, 0755); err != nil {
+			errors = append(errors, fmt.Sprintf("%s: failed to create parent dir: %v", item, err))
+			continue
+		}
+
+		note := ""
+		switch action {
+		case ignoredShare:
+			// On Windows, we need to use absolute paths and handle directory symlinks differently
+			absSourcePath, err := filepath.Abs(sourcePath)
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("%s: failed to get absolute path: %v", item, err))
+				continue
+			}
+			if err := createSymlink(absSourcePath, targetPath, sourceInfo.IsDir()); err != nil {
+				errors = append(errors, fmt.Sprintf("%s: %v", item, err))
+				continue
+			}
+			linkedItems = append(linkedItems, item)
+
+		case ignoredCopy, ignoredReflink:
+			start := time.Now()
+			stats, err := copyTree(sourcePath, targetPath, rule.Hardlink)
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("%s: copy failed: %v", item, err))
+				continue
+			}
+			note = fmt.Sprintf(" (%s, %s)", stats, time.Since(start).Round(time.Millisecond))
+
+		case ignoredFresh:
+			if err := os.Mkdir(targetPath, sourceInfo.Mode().Perm()); err != nil {
+				errors = append(errors, fmt.Sprintf("%s: %v", item, err))
+				continue
+			}
+		}
+
+		counts[action]++
+		log.Printf("  %s: %s%s", action, item, note)
+	}
+
+	var summary []string
+	for _, action := range ignoredActions {
+		if counts[action] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[action], action))
+		}
+	}
+	if len(summary) > 0 {
+		log.Printf("Gitignored items: %s", strings.Join(summary, ", "))
+	}
+
+	// A symlink doesn't match directory patterns like node_modules/, so exclude it explicitly
+	if len(linkedItems) > 0 {
+		if err := addToGitExclude(log, worktreeDir, linkedItems); err != nil {
+			log.Warnf("failed to exclude the symlinks from git: %v", err)
+		} else {
+			log.Printf("Excluded %d symlinks in the worktree's info/exclude", len(linkedItems))
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("some gitignored items failed:\n  %s", strings.Join(errors, "\n  "))
+	}
+
+	return nil
+}
+
+// copyStats counts what copyTree did
+type copyStats struct {
+	Bytes                         int64
+	Reflinked, Hardlinked, Copied int
+}
+
+// String describes the size of a copy and how its files were made, e.g.
+// "12.4 MB in 1032 files, reflinked"
+func (s copyStats) String() string {
+	files := s.Reflinked + s.Hardlinked + s.Copied
+	var how []string
+	for _, m := range []struct {
+		n    int
+		verb string
+	}{{s.Reflinked, "reflinked"}, {s.Hardlinked, "hardlinked"}, {s.Copied, "copied"}} {
+		switch {
+		case m.n == 0:
+		case m.n == files:
+			how = append(how, m.verb)
+		default:
+			how = append(how, fmt.Sprintf("%d %s", m.n, m.verb))
+		}
+	}
+	desc := fmt.Sprintf("%s in %d %s", formatBytes(s.Bytes), files, plural(files, "file", "files"))
+	if len(how) > 0 {
+		desc += ", " + strings.Join(how, ", ")
+	}
+	return desc
+}
+
+// copyTree copies a file or directory tree, keeping modes and copying symlinks as symlinks.
+// Each file is reflinked where the filesystem supports it, else hardlinked if allowed,
+// else copied. A method that fails once isn't tried again for the rest of the tree.
+func copyTree(src, dst string, hardlink bool) (copyStats, error) {
+	var stats copyStats
+	tryReflink := true
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !info.Mode().IsRegular():
+			return nil // sockets, pipes and devices aren't worth copying
+		}
+		stats.Bytes += info.Size()
+
+		if tryReflink {
+			if err := cloneFile(p, target, info.Mode().Perm()); err == nil {
+				stats.Reflinked++
+				return nil
+			}
+			tryReflink = false
+		}
+		if hardlink {
+			if err := os.Link(p, target); err == nil {
+				stats.Hardlinked++
+				return nil
+			}
+			hardlink = false
+		}
+		stats.Copied++
+		return copyFile(p, target, info.Mode().Perm())
+	})
+	return stats, err
+}
+
+// copyFile copies a regular file's contents into a new file
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// formatBytes formats a size with a binary unit, e.g. "12.4 MB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
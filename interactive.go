@@ -2,9 +2,11 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/term"
 )
 
 // selectModel is a bubbletea model for selection UI
@@ -70,10 +72,10 @@ func runSelect(label string, items []string) int {
 		selected: -1,
 	}
 
-	p := tea.NewProgram(m)
+	p := tea.NewProgram(m, tea.WithOutput(textOut))
 	finalModel, err := p.Run()
 	if err != nil {
-		fmt.Printf("Error running selection: %v\n", err)
+		fmt.Fprintf(textOut, "Error running selection: %v\n", err)
 		return -1
 	}
 
@@ -91,21 +93,21 @@ func interactiveSelectMapping(config *Config) (folderName, branchName string, ok
 	}
 
 	if len(activeFolders) == 0 {
-		fmt.Println("No active worktrees. Nothing to remove.")
+		fmt.Fprintln(textOut, "No active worktrees. Nothing to remove.")
 		return "", "", false
 	}
 
 	// Build items with "folder -> branch" format
 	items := make([]string, len(activeFolders)+1)
 	for i, f := range activeFolders {
-		items[i] = fmt.Sprintf("%s -> %s", f.Name, f.Branch)
+		items[i] = fmt.Sprintf("%s -> %s", f.Name, f.branchLabel())
 	}
 	items[len(activeFolders)] = "Cancel"
 
 	idx := runSelect("Select a worktree to remove:", items)
 
 	if idx == -1 || idx == len(activeFolders) {
-		fmt.Println("Cancelled.")
+		fmt.Fprintln(textOut, "Cancelled.")
 		return "", "", false
 	}
 
@@ -191,10 +193,10 @@ func promptTextInput(label, defaultValue string) (string, bool) {
 		value: defaultValue,
 	}
 
-	p := tea.NewProgram(m)
+	p := tea.NewProgram(m, tea.WithOutput(textOut))
 	finalModel, err := p.Run()
 	if err != nil {
-		fmt.Printf("Error running input: %v\n", err)
+		fmt.Fprintf(textOut, "Error running input: %v\n", err)
 		return "", false
 	}
 
@@ -230,7 +232,7 @@ func selectFolderForBranch(config *Config, branchName string) (string, bool) {
 
 	for _, f := range inactiveFolders {
 		status := formatTimeAgo(f.LastUsed)
-		items = append(items, fmt.Sprintf("%s (was: %s, %s)", f.Name, f.Branch, status))
+		items = append(items, fmt.Sprintf("%s (was: %s, %s)", f.Name, f.branchLabel(), status))
 	}
 	items = append(items, "Cancel")
 
@@ -252,3 +254,9 @@ func selectFolderForBranch(config *Config, branchName string) (string, bool) {
 	// Selected a previous folder (offset by 2 for the two options at the top)
 	return inactiveFolders[idx-2].Name, true
 }
+
+// isTerminal reports whether f is connected to a terminal
+func isTerminal(f *os.File) bool {
+	// Character devices like /dev/null aren't terminals, so ask the tty driver
+	return term.IsTerminal(f.Fd())
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func runList(args []string) int {
+	fs := newFlagSet("list")
+	formatFlag := addFormatFlag(fs)
+
+	args, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(args) > 0 {
+		return usageError(fs, "unexpected argument '%s'", args[0])
+	}
+
+	out, err := setupOutput(*formatFlag)
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	folders := getRecentFolders(ws.config)
+	if out != nil {
+		doc := jsonList{jsonHeader: header("list"), Folders: []jsonFolder{}}
+		for _, f := range folders {
+			if out.stream {
+				out.write(line("folder", toJSONFolder(f)))
+			}
+			doc.Folders = append(doc.Folders, toJSONFolder(f))
+		}
+		if !out.stream {
+			out.write(doc)
+		}
+		return exitOK
+	}
+
+	if len(folders) == 0 {
+		fmt.Fprintln(textOut, "No folder history.")
+		return exitOK
+	}
+
+	// Calculate column widths
+	folderWidth, branchWidth, statusWidth, usedWidth := len("FOLDER"), len("BRANCH"), len("inactive"), len("LAST USED")
+	for _, f := range folders {
+		if len(f.Name) > folderWidth {
+			folderWidth = len(f.Name)
+		}
+		if len(f.branchLabel()) > branchWidth {
+			branchWidth = len(f.branchLabel())
+		}
+		if len(formatTimeAgo(f.LastUsed)) > usedWidth {
+			usedWidth = len(formatTimeAgo(f.LastUsed))
+		}
+	}
+
+	// Only highlight active folders when a person is looking at the output
+	color := isTerminal(textOut) && os.Getenv("NO_COLOR") == ""
+
+	// Print header
+	fmt.Fprintf(textOut, "%-*s  %-*s  %-*s  %-*s  %s\n", folderWidth, "FOLDER", branchWidth, "BRANCH", statusWidth, "STATUS", usedWidth, "LAST USED", "REPOS")
+
+	// Print rows
+	for _, f := range folders {
+		status := "inactive"
+		if f.IsActive {
+			status = "active"
+		}
+		timeAgo := formatTimeAgo(f.LastUsed)
+
+		// Older entries don't record their repos
+		repos := "-"
+		if len(f.Repos) > 0 {
+			names := make([]string, len(f.Repos))
+			for i, r := range f.Repos {
+				names[i] = r.Name
+			}
+			repos = strings.Join(names, ",")
+		}
+
+		if f.IsActive && color {
+			fmt.Fprintf(textOut, "\033[32m%-*s  %-*s  %-*s  %-*s  %s\033[0m\n", folderWidth, f.Name, branchWidth, f.branchLabel(), statusWidth, status, usedWidth, timeAgo, repos)
+		} else {
+			fmt.Fprintf(textOut, "%-*s  %-*s  %-*s  %-*s  %s\n", folderWidth, f.Name, branchWidth, f.branchLabel(), statusWidth, status, usedWidth, timeAgo, repos)
+		}
+	}
+
+	return exitOK
+}
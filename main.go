@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -8,219 +9,305 @@ import (
 	"strings"
 )
 
-func main() {
-	// Define flags
-	dirsFlag := flag.String("dirs", "", "Comma-separated list of directories to create worktrees for. If not set, uses all directories with .git subfolder")
-	removeFlag := flag.Bool("remove", false, "Remove worktrees instead of creating them")
-	folderFlag := flag.String("folder", "", "Custom folder name for the worktree (defaults to branch name). Mapping is saved for later use.")
-	listFlag := flag.Bool("list", false, "List all saved folder-to-branch mappings")
+// Exit codes shared by all commands
+const (
+	exitOK          = 0   // success, or cancelled by the user at a prompt
+	exitFailure     = 1   // the operation failed, in at least one repo
+	exitUsage       = 2   // bad flags or arguments
+	exitInterrupted = 130 // stopped by Ctrl-C
+)
 
-	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, "Usage: worktree_plus [-dirs=dir1,dir2,...] [-folder=name] [-remove] <branch-name>")
-		fmt.Fprintln(os.Stderr, "       worktree_plus -remove    (interactive selection)")
-		fmt.Fprintln(os.Stderr, "       worktree_plus -list")
-		fmt.Fprintln(os.Stderr, "\nFlags must come before the branch name.")
-		fmt.Fprintln(os.Stderr, "")
-		flag.PrintDefaults()
-	}
+// command is a worktree_plus subcommand
+type command struct {
+	name    string
+	args    string // argument synopsis shown after the name
+	summary string
+	run     func(args []string) int
+}
 
-	flag.Parse()
+// commands lists every subcommand in the order shown by help
+var commands []*command
 
-	// Get current working directory
-	cwd, err := os.Getwd()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
-		os.Exit(1)
+func init() {
+	commands = []*command{
+		{name: "create", args: "[flags] <branch>", summary: "Create worktrees for a branch in every repo", run: runCreate},
+		{name: "remove", args: "[flags] [branch]", summary: "Remove a folder's worktrees (interactive selection without a branch)", run: runRemove},
+		{name: "prune", args: "-merged [flags]", summary: "Find folders whose branches are merged and remove them", run: runPrune},
+		{name: "reopen", args: "[flags] <folder>", summary: "Recreate an inactive folder's worktrees on the branch it last used", run: runReopen},
+		{name: "switch", args: "[flags] <folder> <branch>", summary: "Check out a different branch in every worktree of an active folder", run: runSwitch},
+		{name: "rename-branch", args: "[flags] <folder> <new-branch>", summary: "Rename the branch of a folder in every repo and in the config", run: runRenameBranch},
+		{name: "rename-folder", args: "[flags] <folder> <new-name>", summary: "Rename a folder, moving its worktrees and history", run: runRenameFolder},
+		{name: "exec", args: "[flags] <folder> -- <command>", summary: "Run a command in every worktree of a folder", run: runExec},
+		{name: "commit", args: "[flags] -m <message> <folder>", summary: "Commit all changes in every worktree of a folder with one message", run: runCommit},
+		{name: "push", args: "[flags] <folder>", summary: "Push every worktree of a folder, setting the upstream on first push", run: runPush},
+		{name: "sync", args: "[flags] <folder>", summary: "Fetch, then rebase or merge every repo of a folder onto its base", run: runSync},
+		{name: "list", args: "[flags]", summary: "List saved folders with their branch and repos", run: runList},
+		{name: "status", args: "[flags] [folder...]", summary: "Show the state of every repo in folders (default: all active)", run: runStatusCommand},
+		{name: "trash", args: "<list|restore|purge> [flags] [args]", summary: "List, restore or purge files set aside when folders were removed", run: runTrash},
+		{name: "migrate-excludes", args: "[flags] [folder...]", summary: "Move symlink patterns from .gitignore to per-worktree excludes", run: runMigrateExcludes},
+		{name: "help", args: "[command]", summary: "Show help for a command", run: runHelp},
 	}
+}
 
-	// Load config
-	config, err := loadConfig(cwd)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Handle -list flag
-	if *listFlag {
-		folders := getRecentFolders(config)
-		if len(folders) == 0 {
-			fmt.Println("No folder history.")
-		} else {
-			// Calculate column widths
-			folderWidth, branchWidth, statusWidth := len("FOLDER"), len("BRANCH"), len("inactive")
-			for _, f := range folders {
-				if len(f.Name) > folderWidth {
-					folderWidth = len(f.Name)
-				}
-				if len(f.Branch) > branchWidth {
-					branchWidth = len(f.Branch)
-				}
-			}
+// legacyModes maps the old mode flags to the command they select
+var legacyModes = map[string]string{
+	"remove": "remove",
+	"list":   "list",
+	"status": "status",
+}
 
-			// Print header
-			fmt.Printf("%-*s  %-*s  %-*s  %s\n", folderWidth, "FOLDER", branchWidth, "BRANCH", statusWidth, "STATUS", "LAST USED")
-
-			// Print rows
-			for _, f := range folders {
-				status := "inactive"
-				if f.IsActive {
-					status = "active"
-				}
-				timeAgo := formatTimeAgo(f.LastUsed)
-
-				if f.IsActive {
-					fmt.Printf("\033[32m%-*s  %-*s  %-*s  %s\033[0m\n", folderWidth, f.Name, branchWidth, f.Branch, statusWidth, status, timeAgo)
-				} else {
-					fmt.Printf("%-*s  %-*s  %-*s  %s\n", folderWidth, f.Name, branchWidth, f.Branch, statusWidth, status, timeAgo)
-				}
-			}
-		}
-		return
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run dispatches to a subcommand and returns the exit code
+func run(args []string) int {
+	if len(args) == 0 {
+		printUsage()
+		return exitUsage
 	}
 
-	// Get positional arguments
-	args := flag.Args()
+	if cmd := findCommand(args[0]); cmd != nil {
+		return finishOutput(cmd.name, cmd.run(args[1:]))
+	}
+	switch args[0] {
+	case "-h", "-help", "--help":
+		printUsage()
+		return exitOK
+	}
 
-	var branchName, folderName string
+	// Old style: worktree_plus [flags] <branch>, with -remove/-list/-status selecting the mode
+	name, rest := translateLegacyArgs(args)
+	return finishOutput(name, findCommand(name).run(rest))
+}
 
-	// Handle interactive remove when no branch name provided
-	if *removeFlag && len(args) < 1 {
-		var ok bool
-		folderName, branchName, ok = interactiveSelectMapping(config)
-		if !ok {
-			os.Exit(0)
+// findCommand looks up a subcommand by name
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
 		}
-	} else {
-		// Branch name is required for non-interactive operations
-		if len(args) < 1 {
-			flag.Usage()
-			os.Exit(1)
-		}
-		branchName = args[0]
-
-		// Determine folder name
-		if *folderFlag != "" {
-			// Use specified folder name
-			folderName = *folderFlag
-		} else if existingFolder, ok := findFolderByBranch(config, branchName); ok {
-			// Look up existing active mapping by branch name
-			folderName = existingFolder
-			fmt.Printf("Using existing mapping: folder '%s' -> branch '%s'\n", folderName, branchName)
-		} else if !*removeFlag {
-			// When creating without -folder, offer folder selection
-			var ok bool
-			folderName, ok = selectFolderForBranch(config, branchName)
-			if !ok {
-				fmt.Println("Cancelled.")
-				os.Exit(0)
+	}
+	return nil
+}
+
+// translateLegacyArgs turns the old flag-based invocation into a command name and its arguments
+func translateLegacyArgs(args []string) (string, []string) {
+	name := "create"
+	var rest []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			flagName, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+			if mode, ok := legacyModes[flagName]; ok && (!hasValue || value == "true") {
+				name = mode
+				continue
 			}
-		} else {
-			// Default to branch name as folder name
-			folderName = branchName
 		}
+		rest = append(rest, arg)
+	}
+	return name, rest
+}
 
-		// When creating, check for conflicts
-		if !*removeFlag {
-			// Check if this exact folder+branch is already active
-			if isExactMatch(config, folderName, branchName) {
-				fmt.Printf("Worktrees for folder '%s' with branch '%s' already exist. Nothing to do.\n", folderName, branchName)
-				os.Exit(0)
-			}
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: worktree_plus <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-17s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun 'worktree_plus help <command>' for its flags. Flags may appear anywhere.")
+	fmt.Fprintln(os.Stderr, "The old forms still work: worktree_plus [flags] <branch>, -remove, -list and -status.")
+	fmt.Fprintln(os.Stderr, "\nExit codes: 0 success, 1 failure in at least one repo, 2 usage error, 130 interrupted.")
+}
 
-			// Check if branch is already in use with a different folder
-			if conflictFolder := checkBranchConflict(config, folderName, branchName); conflictFolder != "" {
-				fmt.Fprintf(os.Stderr, "Error: branch '%s' is already active in folder '%s'\n", branchName, conflictFolder)
-				fmt.Fprintf(os.Stderr, "Remove the existing worktrees first with: worktree_plus -remove %s\n", branchName)
-				os.Exit(1)
-			}
+func runHelp(args []string) int {
+	if len(args) == 0 {
+		printUsage()
+		return exitOK
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n", args[0])
+		printUsage()
+		return exitUsage
+	}
+	cmd.run([]string{"-h"})
+	return exitOK
+}
 
-			// Save/update the mapping
-			touchFolder(config, folderName, branchName)
-			if err := saveConfig(cwd, config); err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: failed to save config: %v\n", err)
-			} else if *folderFlag != "" {
-				fmt.Printf("Saved mapping: folder '%s' -> branch '%s'\n", folderName, branchName)
-			}
+// newFlagSet creates the flag set for a command, with help output describing it
+func newFlagSet(name string) *flag.FlagSet {
+	return commandFlagSet(findCommand(name))
+}
+
+// commandFlagSet creates the flag set for cmd, which may be a nested command like "trash list"
+func commandFlagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: worktree_plus %s %s\n\n%s\n", cmd.name, cmd.args, cmd.summary)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintln(os.Stderr, "\nFlags:")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// parseArgs parses flags that may appear anywhere among the positional arguments.
+// Everything after "--" is positional. When it returns false, the command should
+// exit with the returned code (help was shown, or the flags were invalid).
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, int, bool) {
+	var tail []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, tail = args[:i], args[i+1:]
+			break
 		}
 	}
 
-	// Determine which directories to process
-	var targetDirs []string
-	if *dirsFlag != "" {
-		// Use specified directories
-		for _, d := range strings.Split(*dirsFlag, ",") {
-			d = strings.TrimSpace(d)
-			if d != "" {
-				// Make absolute if relative
-				if !filepath.IsAbs(d) {
-					d = filepath.Join(cwd, d)
-				}
-				targetDirs = append(targetDirs, d)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, exitOK, false
 			}
+			lastError = err.Error()
+			detectOutput(fs)
+			return nil, exitUsage, false
 		}
-	} else {
-		// Find all directories with .git subfolder
-		targetDirs, err = findGitDirs(cwd)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error finding git directories: %v\n", err)
-			os.Exit(1)
+		if fs.NArg() == 0 {
+			break
 		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
 	}
+	return append(positional, tail...), exitOK, true
+}
+
+// usageError reports a problem with a command's arguments
+func usageError(fs *flag.FlagSet, format string, args ...any) int {
+	fmt.Fprintf(os.Stderr, "Error: "+format+"\n\n", args...)
+	fs.Usage()
+	lastError = fmt.Sprintf(format, args...)
+	detectOutput(fs)
+	return exitUsage
+}
+
+// fail prints an error and returns the failure exit code
+func fail(format string, args ...any) int {
+	fmt.Fprintf(os.Stderr, "Error: "+format+"\n", args...)
+	lastError = fmt.Sprintf(format, args...)
+	return exitFailure
+}
+
+// addFormatFlag registers -format on a command
+func addFormatFlag(fs *flag.FlagSet) *string {
+	return fs.String("format", formatText, "Output format: text, json or ndjson")
+}
 
-	if len(targetDirs) == 0 {
-		fmt.Fprintln(os.Stderr, "No directories found to process")
-		os.Exit(1)
+// textOut receives everything meant for humans. With -format json or ndjson, stdout
+// carries the machine-readable output and textOut is stderr.
+var textOut = os.Stdout
+
+// machineOut is the running command's machine-readable output, nil for text. lastError
+// is the failure finishOutput reports when the command stops before writing a result.
+var (
+	machineOut *jsonWriter
+	lastError  string
+)
+
+// setupOutput validates -format and returns the machine-readable writer, or nil for text
+func setupOutput(format string) (*jsonWriter, error) {
+	format, err := parseFormat(format)
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Printf("Processing %d directories for branch '%s' (folder: '%s')\n", len(targetDirs), branchName, folderName)
+	// Machine-readable output owns stdout; everything meant for humans goes to stderr
+	out := newJSONWriter(os.Stdout, format)
+	if out != nil {
+		textOut = os.Stderr
+	}
+	machineOut = out
+	return out, nil
+}
 
-	// Process each directory
-	for _, dir := range targetDirs {
-		if *removeFlag {
-			err = removeWorktree(dir, folderName, branchName)
-		} else {
-			err = createWorktree(dir, folderName, branchName)
-		}
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", dir, err)
-		}
+// detectOutput sets up the output of a command that stops on bad arguments before
+// calling setupOutput itself, going by whatever -format was parsed so far
+func detectOutput(fs *flag.FlagSet) {
+	if f := fs.Lookup("format"); f != nil && machineOut == nil {
+		setupOutput(f.Value.String())
+	}
+}
+
+// finishOutput writes an outcome object when a command with machine-readable output
+// stopped without writing its result, e.g. on bad arguments or with nothing to do
+func finishOutput(kind string, code int) int {
+	if machineOut == nil || machineOut.wrote {
+		return code
 	}
+	outcome := jsonOutcome{jsonHeader: header(kind), OK: code == exitOK}
+	switch {
+	case outcome.OK:
+	case lastError != "":
+		outcome.Error = lastError
+	case code == exitInterrupted:
+		outcome.Error = "interrupted"
+	default:
+		outcome.Error = fmt.Sprintf("failed with exit code %d", code)
+	}
+	machineOut.write(outcome)
+	return code
+}
 
-	// Clean up folder directory after all removals
-	if *removeFlag {
-		folderDir := filepath.Join(filepath.Dir(cwd), folderName)
+// workspace is the directory worktree_plus runs in, holding the main checkouts and the config
+type workspace struct {
+	cwd    string
+	config *Config
+}
 
-		// Clean up symlinks and handle remaining files
-		if err := cleanupFolderDir(folderDir, cwd); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: error during cleanup: %v\n", err)
-		}
+// loadWorkspace loads the config for the current directory
+func loadWorkspace() (*workspace, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("getting current directory: %w", err)
+	}
 
-		// Try to remove the folder directory if it's now empty
-		entries, err := os.ReadDir(folderDir)
-		if err == nil && len(entries) == 0 {
-			if err := os.Remove(folderDir); err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: could not remove empty folder directory %s: %v\n", folderDir, err)
-			} else {
-				fmt.Printf("Removed empty folder directory %s\n", folderDir)
-			}
-		} else if err == nil && len(entries) > 0 {
-			fmt.Printf("Folder directory %s not removed (still contains files)\n", folderDir)
-		}
+	config, err := loadConfig(cwd)
+	if err != nil {
+		return nil, fmt.Errorf("loading config: %w", err)
+	}
 
-		// Deactivate the folder in config (keeps history)
-		deactivateFolder(config, folderName)
-		if err := saveConfig(cwd, config); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to update config: %v\n", err)
-		} else {
-			fmt.Printf("Deactivated folder '%s' (kept in history)\n", folderName)
-		}
+	return &workspace{cwd: cwd, config: config}, nil
+}
+
+// save writes the config back, printing a warning on failure. Returns whether it succeeded.
+func (ws *workspace) save() bool {
+	if err := saveConfig(ws.cwd, ws.config); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save config: %v\n", err)
+		return false
 	}
+	return true
+}
+
+// folderDir returns the directory that holds a folder's worktrees: ../<folder>
+func (ws *workspace) folderDir(folderName string) string {
+	return filepath.Join(filepath.Dir(ws.cwd), folderName)
+}
 
-	// Symlink root directory files to folder directory after creating worktrees
-	if !*removeFlag {
-		folderDir := filepath.Join(filepath.Dir(cwd), folderName)
-		if err := symlinkRootFiles(cwd, folderDir, targetDirs); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to symlink some root files: %v\n", err)
+// parseDirs turns a comma-separated -dirs value into absolute directories
+func (ws *workspace) parseDirs(value string) []string {
+	var dirs []string
+	for _, d := range strings.Split(value, ",") {
+		d = strings.TrimSpace(d)
+		if d != "" {
+			// Make absolute if relative
+			if !filepath.IsAbs(d) {
+				d = filepath.Join(ws.cwd, d)
+			}
+			dirs = append(dirs, d)
 		}
 	}
+	return dirs
 }
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func runMigrateExcludes(args []string) int {
+	fs := newFlagSet("migrate-excludes")
+	dryRunFlag := fs.Bool("dry-run", false, "Show what would be migrated without changing anything")
+	refreshGlobalFlag := fs.Bool("refresh-global", false, "Also copy the global excludes file into each worktree's exclude file again, picking up edits made since it was copied")
+	jobsFlag := fs.Int("jobs", 0, "Number of repos to process in parallel (default 4, or jobs in config; 1 streams output)")
+
+	folderNames, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+	if len(folderNames) == 0 {
+		for name, info := range ws.config.Folders {
+			if info.IsActive {
+				folderNames = append(folderNames, name)
+			}
+		}
+		sort.Strings(folderNames)
+	}
+	if len(folderNames) == 0 {
+		fmt.Fprintln(textOut, "No active folders.")
+		return exitOK
+	}
+
+	// Ctrl-C cancels repos that haven't started yet
+	ctx, stop := interruptContext()
+	defer stop()
+
+	failed := 0
+	for _, folderName := range folderNames {
+		target, err := loadFolderTarget(ws, folderName)
+		if err != nil {
+			return fail("%v", err)
+		}
+
+		fmt.Fprintf(textOut, "\nFolder '%s'\n", folderName)
+		results := runRepos(ctx, target.dirs, resolveJobs(ws.config, *jobsFlag), func(ctx context.Context, dir string, log *repoLog) (string, error) {
+			path := target.records[dir].Path
+			detail, err := migrateGitignore(log, path, *dryRunFlag)
+			if err != nil || !*refreshGlobalFlag {
+				return detail, err
+			}
+			changed, err := refreshGlobalExcludes(path, *dryRunFlag)
+			switch {
+			case err != nil:
+				return detail, err
+			case changed && *dryRunFlag:
+				log.Printf("Would refresh the copy of the global excludes file")
+				return detail + ", would refresh global excludes", nil
+			case changed:
+				log.Printf("Refreshed the copy of the global excludes file")
+				return detail + ", global excludes refreshed", nil
+			}
+			return detail, nil
+		})
+		printSummaryTable(results)
+		failed += countFailed(results)
+	}
+
+	return exitCodeFor(ctx, failed)
+}
+
+// migrateGitignore undoes what older versions did to hide the shared symlinks of a
+// worktree: the patterns appended to its .gitignore move to the worktree's own exclude
+// file, and .gitignore loses its assume-unchanged bit so that git sees it again
+func migrateGitignore(log *repoLog, worktreePath string, dryRun bool) (string, error) {
+	if _, err := os.Stat(worktreePath); err != nil {
+		return "", fmt.Errorf("worktree does not exist at %s", worktreePath)
+	}
+
+	gitignorePath := filepath.Join(worktreePath, ".gitignore")
+	data, err := os.ReadFile(gitignorePath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	// Our section is the header and the anchored patterns after it that name a symlink,
+	// preceded by a blank line. Any other line ends it.
+	var kept, items []string
+	inSection := false
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == excludeHeader:
+			inSection = true
+			if n := len(kept); n > 0 && kept[n-1] == "" {
+				kept = kept[:n-1]
+			}
+		case inSection && strings.HasPrefix(trimmed, "/") && isSymlink(filepath.Join(worktreePath, trimmed)):
+			items = append(items, strings.TrimPrefix(trimmed, "/"))
+		default:
+			if trimmed != "" {
+				inSection = false
+			}
+			kept = append(kept, line)
+		}
+	}
+
+	// git ls-files -v tags a tracked file with a lowercase letter when it is assume-unchanged
+	tracked := exec.Command("git", "ls-files", "-v", "--", ".gitignore")
+	tracked.Dir = worktreePath
+	output, err := tracked.Output()
+	if err != nil {
+		return "", fmt.Errorf("git ls-files failed: %w", err)
+	}
+	tag := strings.TrimSpace(string(output))
+	isTracked := tag != ""
+	assumeUnchanged := strings.HasPrefix(tag, "h ")
+
+	if len(items) == 0 && !assumeUnchanged {
+		log.Printf("Nothing to migrate")
+		return "nothing to migrate", nil
+	}
+
+	n := len(items)
+	if dryRun {
+		if n == 0 {
+			log.Printf("Would clear the assume-unchanged bit of .gitignore")
+			return "would clear assume-unchanged", nil
+		}
+		log.Printf("Would move %d %s to info/exclude: %s", n, plural(n, "pattern", "patterns"), summarizeList(items, 5))
+		return fmt.Sprintf("would move %d %s", n, plural(n, "pattern", "patterns")), nil
+	}
+
+	// Git only looks at .gitignore again, e.g. to check it out, once the bit is gone
+	if assumeUnchanged {
+		cmd := exec.Command("git", "update-index", "--no-assume-unchanged", "--", ".gitignore")
+		cmd.Dir = worktreePath
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("failed to clear assume-unchanged on .gitignore: %w", err)
+		}
+	}
+
+	// Exclude the symlinks the new way before they show up as untracked
+	checkedOut := false
+	if n > 0 {
+		if err := addToGitExclude(log, worktreePath, items); err != nil {
+			return "", err
+		}
+
+		// Without our section, a .gitignore that matches HEAD is simply checked out again
+		rest := strings.TrimRight(strings.Join(kept, "\n"), "\n")
+		var err error
+		switch {
+		case isTracked && rest == headGitignore(worktreePath):
+			cmd := exec.Command("git", "checkout", "HEAD", "--", ".gitignore")
+			cmd.Dir = worktreePath
+			err = cmd.Run()
+			checkedOut = true
+		case !isTracked && rest == "":
+			err = os.Remove(gitignorePath)
+		default:
+			err = os.WriteFile(gitignorePath, []byte(rest+"\n"), 0644)
+		}
+		if err != nil {
+			return "", fmt.Errorf("cannot restore .gitignore: %w", err)
+		}
+		log.Printf("Moved %d %s to info/exclude", n, plural(n, "pattern", "patterns"))
+	}
+
+	// Edits the user made on top of ours stay, and are visible to git from now on
+	if isTracked && !checkedOut {
+		diff := exec.Command("git", "diff", "--quiet", "HEAD", "--", ".gitignore")
+		diff.Dir = worktreePath
+		if diff.Run() != nil {
+			log.Warnf(".gitignore still differs from HEAD, check it with git diff")
+		}
+	}
+
+	if n == 0 {
+		return "assume-unchanged cleared", nil
+	}
+	return fmt.Sprintf("moved %d %s to info/exclude", n, plural(n, "pattern", "patterns")), nil
+}
+
+// headGitignore returns the .gitignore of the worktree's HEAD commit without trailing
+// newlines, or "" if it has none
+func headGitignore(worktreePath string) string {
+	cmd := exec.Command("git", "show", "HEAD:.gitignore")
+	cmd.Dir = worktreePath
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(string(output), "\n")
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// logLine is a single buffered line of repo output
+type logLine struct {
+	text  string
+	isErr bool
+}
+
+// repoLog collects the output of one repository. In streaming mode lines go straight
+// to stdout/stderr; in buffered mode they are held until flush so that repos processed
+// in parallel don't interleave.
+type repoLog struct {
+	name     string
+	buffered bool
+	onLine   func(string) // receives every line, used for live progress; may be nil
+
+	mu    sync.Mutex
+	lines []logLine
+}
+
+// newRepoLog creates a log whose lines are prefixed with the repository name
+func newRepoLog(name string, buffered bool) *repoLog {
+	return &repoLog{name: name, buffered: buffered}
+}
+
+// Printf writes a "[repo] ..." line to stdout
+func (l *repoLog) Printf(format string, args ...any) {
+	l.write(fmt.Sprintf("[%s] %s", l.name, fmt.Sprintf(format, args...)), false)
+}
+
+// Warnf writes a "[repo] Warning: ..." line to stderr
+func (l *repoLog) Warnf(format string, args ...any) {
+	l.write(fmt.Sprintf("[%s] Warning: %s", l.name, fmt.Sprintf(format, args...)), true)
+}
+
+// Errorf writes a "[repo] Error: ..." line to stderr
+func (l *repoLog) Errorf(format string, args ...any) {
+	l.write(fmt.Sprintf("[%s] Error: %s", l.name, fmt.Sprintf(format, args...)), true)
+}
+
+// Stdout returns a writer for subprocess output that belongs on stdout
+func (l *repoLog) Stdout() io.Writer {
+	return &lineWriter{log: l}
+}
+
+// Stderr returns a writer for subprocess output that belongs on stderr
+func (l *repoLog) Stderr() io.Writer {
+	return &lineWriter{log: l, isErr: true}
+}
+
+// PrefixedStdout is like Stdout but starts every line with "[repo] "
+func (l *repoLog) PrefixedStdout() *lineWriter {
+	return &lineWriter{log: l, prefix: "[" + l.name + "] "}
+}
+
+// PrefixedStderr is like Stderr but starts every line with "[repo] "
+func (l *repoLog) PrefixedStderr() *lineWriter {
+	return &lineWriter{log: l, isErr: true, prefix: "[" + l.name + "] "}
+}
+
+func (l *repoLog) write(text string, isErr bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.onLine != nil {
+		l.onLine(text)
+	}
+	if l.buffered {
+		l.lines = append(l.lines, logLine{text: text, isErr: isErr})
+		return
+	}
+	if isErr {
+		fmt.Fprintln(os.Stderr, text)
+	} else {
+		fmt.Fprintln(textOut, text)
+	}
+}
+
+// flush writes any buffered lines to stdout/stderr in their original order
+func (l *repoLog) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, line := range l.lines {
+		if line.isErr {
+			fmt.Fprintln(os.Stderr, line.text)
+		} else {
+			fmt.Fprintln(textOut, line.text)
+		}
+	}
+	l.lines = nil
+}
+
+// lineWriter splits subprocess output into lines and hands them to a repoLog
+type lineWriter struct {
+	log     *repoLog
+	isErr   bool
+	prefix  string
+	partial bytes.Buffer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial.Write(p)
+	for {
+		data := w.partial.Bytes()
+		i := bytes.IndexAny(data, "\r\n")
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(data[:i]), " ")
+		w.partial.Next(i + 1)
+		if line != "" {
+			w.log.write(w.prefix+line, w.isErr)
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes out a last line that didn't end with a newline
+func (w *lineWriter) Flush() {
+	if line := strings.TrimRight(w.partial.String(), " "); line != "" {
+		w.log.write(w.prefix+line, w.isErr)
+	}
+	w.partial.Reset()
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// defaultJobs is how many repos are processed at once when neither -jobs nor the config say otherwise
+const defaultJobs = 4
+
+// errCancelled is reported for repos that were never started because the run was cancelled
+var errCancelled = errors.New("cancelled")
+
+// repoTask does the work for one repository, reporting through log.
+// It returns a short description of what happened for the summary table.
+type repoTask func(ctx context.Context, dir string, log *repoLog) (string, error)
+
+// repoResult is the outcome of running a task in one repository
+type repoResult struct {
+	Dir    string
+	Detail string
+	Err    error
+}
+
+// interruptKey holds the cancel function of an interruptContext
+type interruptKey struct{}
+
+// interruptContext is cancelled by Ctrl-C or SIGTERM. The live view of runRepos puts the
+// terminal in raw mode, where Ctrl-C arrives as a key press instead of a signal, so it
+// cancels this context itself.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	return context.WithValue(ctx, interruptKey{}, cancel), func() {
+		stop()
+		cancel()
+	}
+}
+
+// runRepos runs task in every directory, at most jobs at a time. With a single job the
+// output streams as it always has. Otherwise each repo's output is buffered and printed
+// as one block, with a live status line per repo while stdout is a terminal.
+func runRepos(ctx context.Context, dirs []string, jobs int, task repoTask) []repoResult {
+	results := make([]repoResult, len(dirs))
+
+	if jobs <= 1 || len(dirs) == 1 {
+		for i, dir := range dirs {
+			results[i] = repoResult{Dir: dir}
+			if ctx.Err() != nil {
+				results[i].Err = errCancelled
+				continue
+			}
+			fmt.Fprintln(textOut)
+			results[i].Detail, results[i].Err = runTask(ctx, task, dir, newRepoLog(filepath.Base(dir), false))
+		}
+		return results
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Ctrl-C in the live view interrupts the whole command, not just this run
+	stop := cancel
+	if interrupt, ok := ctx.Value(interruptKey{}).(context.CancelFunc); ok {
+		stop = interrupt
+	}
+
+	names := make([]string, len(dirs))
+	logs := make([]*repoLog, len(dirs))
+	for i, dir := range dirs {
+		names[i] = filepath.Base(dir)
+		logs[i] = newRepoLog(names[i], true)
+	}
+
+	var ui *tea.Program
+	if isTerminal(textOut) {
+		ui = tea.NewProgram(newProgressModel(names, stop), tea.WithOutput(textOut))
+		for i := range logs {
+			logs[i].onLine = func(line string) { ui.Send(repoLineMsg{index: i, line: line}) }
+		}
+	}
+
+	// Without a live view, print each repo's block as soon as it finishes
+	var printMu sync.Mutex
+	finish := func(i int) {
+		if ui != nil {
+			ui.Send(repoDoneMsg{index: i, err: results[i].Err})
+			return
+		}
+		printMu.Lock()
+		fmt.Fprintln(textOut)
+		logs[i].flush()
+		printMu.Unlock()
+	}
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs && w < len(dirs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = repoResult{Dir: dirs[i]}
+				if ctx.Err() != nil {
+					results[i].Err = errCancelled
+					finish(i)
+					continue
+				}
+				if ui != nil {
+					ui.Send(repoStartMsg{index: i})
+				}
+				results[i].Detail, results[i].Err = runTask(ctx, task, dirs[i], logs[i])
+				finish(i)
+			}
+		}()
+	}
+	go func() {
+		for i := range dirs {
+			queue <- i
+		}
+		close(queue)
+	}()
+
+	if ui == nil {
+		wg.Wait()
+		return results
+	}
+
+	go func() {
+		wg.Wait()
+		ui.Send(progressDoneMsg{})
+	}()
+	if _, err := ui.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error running progress view: %v\n", err)
+	}
+	wg.Wait()
+
+	for _, l := range logs {
+		fmt.Fprintln(textOut)
+		l.flush()
+	}
+	return results
+}
+
+// runTask runs task for one repo and records any error at the end of its log
+func runTask(ctx context.Context, task repoTask, dir string, log *repoLog) (string, error) {
+	detail, err := task(ctx, dir, log)
+	if err != nil {
+		log.Errorf("%v", err)
+	}
+	return detail, err
+}
+
+// printSummaryTable prints one row per repo with its result
+func printSummaryTable(results []repoResult) {
+	repoWidth, resultWidth := len("REPO"), len("RESULT")
+	for _, r := range results {
+		if len(filepath.Base(r.Dir)) > repoWidth {
+			repoWidth = len(filepath.Base(r.Dir))
+		}
+		if errors.Is(r.Err, errTimeout) {
+			resultWidth = len("timed out")
+		}
+	}
+
+	fmt.Fprintln(textOut)
+	fmt.Fprintf(textOut, "%-*s  %-*s  %s\n", repoWidth, "REPO", resultWidth, "RESULT", "DETAIL")
+	for _, r := range results {
+		result, detail := "ok", r.Detail
+		switch {
+		case errors.Is(r.Err, errTimeout):
+			// A slow remote is worth telling apart from a real failure
+			result, detail = "timed out", r.Err.Error()
+		case r.Err != nil:
+			result, detail = "failed", r.Err.Error()
+		}
+		fmt.Fprintf(textOut, "%-*s  %-*s  %s\n", repoWidth, filepath.Base(r.Dir), resultWidth, result, detail)
+	}
+}
+
+// countFailed returns how many results carry an error
+func countFailed(results []repoResult) int {
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// repoState is the progress of a single repo in the live view
+type repoState int
+
+const (
+	repoPending repoState = iota
+	repoRunning
+	repoSucceeded
+	repoFailed
+)
+
+type repoStartMsg struct{ index int }
+
+type repoLineMsg struct {
+	index int
+	line  string
+}
+
+type repoDoneMsg struct {
+	index int
+	err   error
+}
+
+type progressDoneMsg struct{}
+
+type spinnerTickMsg struct{}
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// progressModel is a bubbletea model showing one status line per repo
+type progressModel struct {
+	names      []string
+	states     []repoState
+	lines      []string
+	frame      int
+	width      int
+	cancel     context.CancelFunc
+	cancelling bool
+	done       bool
+}
+
+func newProgressModel(names []string, cancel context.CancelFunc) progressModel {
+	return progressModel{
+		names:  names,
+		states: make([]repoState, len(names)),
+		lines:  make([]string, len(names)),
+		cancel: cancel,
+	}
+}
+
+func spinnerTick() tea.Cmd {
+	return tea.Tick(100*time.Millisecond, func(time.Time) tea.Msg {
+		return spinnerTickMsg{}
+	})
+}
+
+func (m progressModel) Init() tea.Cmd {
+	return spinnerTick()
+}
+
+func (m progressModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" && !m.cancelling {
+			m.cancelling = true
+			m.cancel()
+		}
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+	case repoStartMsg:
+		m.states[msg.index] = repoRunning
+	case repoLineMsg:
+		m.lines[msg.index] = strings.TrimPrefix(msg.line, "["+m.names[msg.index]+"] ")
+	case repoDoneMsg:
+		if msg.err != nil {
+			m.states[msg.index] = repoFailed
+			m.lines[msg.index] = msg.err.Error()
+		} else {
+			m.states[msg.index] = repoSucceeded
+		}
+	case progressDoneMsg:
+		m.done = true
+		return m, tea.Quit
+	case spinnerTickMsg:
+		m.frame = (m.frame + 1) % len(spinnerFrames)
+		return m, spinnerTick()
+	}
+	return m, nil
+}
+
+func (m progressModel) View() string {
+	// Clear the live view once finished; the full logs and summary are printed after it
+	if m.done {
+		return ""
+	}
+
+	nameWidth := 0
+	for _, name := range m.names {
+		if len(name) > nameWidth {
+			nameWidth = len(name)
+		}
+	}
+
+	s := "\n"
+	for i, name := range m.names {
+		var icon, line string
+		switch m.states[i] {
+		case repoPending:
+			icon, line = "·", "waiting"
+		case repoRunning:
+			icon, line = spinnerFrames[m.frame], m.lines[i]
+		case repoSucceeded:
+			icon, line = "✓", "done"
+		case repoFailed:
+			icon, line = "✗", m.lines[i]
+		}
+		row := fmt.Sprintf("  %s %-*s  %s", icon, nameWidth, name, line)
+		if m.width > 0 && len([]rune(row)) > m.width {
+			row = string([]rune(row)[:m.width])
+		}
+		s += row + "\n"
+	}
+
+	if m.cancelling {
+		s += "\nCancelling, waiting for running repos to finish...\n"
+	} else {
+		s += "\n(ctrl+c to cancel)\n"
+	}
+	return s
+}
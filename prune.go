@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mergedFolder is an active folder whose work has landed in every repo
+type mergedFolder struct {
+	Name    string
+	Info    *FolderInfo
+	Reasons []string // per repo, why its branch counts as done
+	Risky   bool     // some worktree has uncommitted work, stashes or unpushed commits
+}
+
+func runPrune(args []string) int {
+	fs := newFlagSet("prune")
+	mergedFlag := fs.Bool("merged", false, "Find folders whose branches are merged into their base, or gone upstream, in every repo")
+	yesFlag := fs.Bool("yes", false, "Remove the folders found without asking; folders with work that may be lost are skipped")
+	deleteBranchFlag := fs.Bool("delete-branch", false, "Also delete the merged branches (see remove -delete-branch)")
+	offlineFlag := fs.Bool("offline", false, "Don't fetch; check against the remote branches as last fetched")
+	fetchTimeoutFlag := fs.Duration("fetch-timeout", defaultFetchTimeout, "Timeout for each git fetch")
+	jobsFlag := fs.Int("jobs", 0, "Number of repos to process in parallel when removing (default 4, or jobs in config)")
+
+	args, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(args) > 0 {
+		return usageError(fs, "unexpected argument '%s'", args[0])
+	}
+	if !*mergedFlag {
+		return usageError(fs, "nothing to prune; use -merged to find folders whose branches are merged")
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+	config := ws.config
+
+	var names []string
+	for name, info := range config.Folders {
+		if info.IsActive {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		fmt.Fprintln(textOut, "No active folders.")
+		return exitOK
+	}
+
+	// Fetch with --prune first so that deleted remote branches show up as gone
+	if !*offlineFlag && !config.Offline {
+		seen := make(map[string]bool)
+		var dirs []string
+		for _, name := range names {
+			repos, err := folderRepos(config, ws.cwd, name)
+			if err != nil {
+				return fail("finding git directories: %v", err)
+			}
+			for _, repo := range repos {
+				if !seen[repo.Dir] {
+					seen[repo.Dir] = true
+					dirs = append(dirs, repo.Dir)
+				}
+			}
+		}
+		if failed := fetchAll(config, dirs, *fetchTimeoutFlag); failed > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: fetch failed in %d of %d repositories, continuing with local state\n", failed, len(dirs))
+		}
+		fmt.Fprintln(textOut)
+	}
+
+	var found []mergedFolder
+	for _, name := range names {
+		folder, ok, err := checkFolderMerged(config, ws.cwd, name)
+		if err != nil {
+			return fail("%v", err)
+		}
+		if ok {
+			found = append(found, folder)
+		}
+	}
+	if len(found) == 0 {
+		fmt.Fprintf(textOut, "None of the %d active folders are merged.\n", len(names))
+		return exitOK
+	}
+
+	// Oldest first, those are the likeliest to be forgotten
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].Info.LastUsed.Before(found[j].Info.LastUsed)
+	})
+	fmt.Fprintf(textOut, "Found %d merged %s:\n", len(found), plural(len(found), "folder", "folders"))
+	rows := [][]string{{"FOLDER", "BRANCH", "LAST USED", "WORK AT RISK", "REPOS"}}
+	for _, f := range found {
+		risk := "no"
+		if f.Risky {
+			risk = "yes"
+		}
+		rows = append(rows, []string{f.Name, f.Info.spec().String(), formatTimeAgo(f.Info.LastUsed), risk, strings.Join(f.Reasons, ", ")})
+	}
+	printTable(rows, "  ")
+	fmt.Fprintln(textOut)
+
+	if !*yesFlag {
+		if !isTerminal(os.Stdin) {
+			fmt.Fprintln(textOut, "Run again with -yes to remove them.")
+			return exitOK
+		}
+		idx := runSelect(fmt.Sprintf("Remove these %d folders?", len(found)), []string{
+			"Cancel",
+			"Remove them",
+		})
+		if idx != 1 {
+			fmt.Fprintln(textOut, "Cancelled.")
+			return exitOK
+		}
+	}
+
+	// Each folder goes through remove, with its safety checks and trash
+	removeArgs := []string{"-jobs", strconv.Itoa(*jobsFlag)}
+	if *deleteBranchFlag {
+		removeArgs = append(removeArgs, "-delete-branch")
+	}
+	var removed, skipped, failed []string
+	for _, f := range found {
+		if f.Risky && *yesFlag {
+			fmt.Fprintf(os.Stderr, "Skipping folder '%s', it has work that may be lost (remove it with: worktree_plus remove -folder %s)\n", f.Name, f.Name)
+			skipped = append(skipped, f.Name)
+			continue
+		}
+		fmt.Fprintf(textOut, "\n== Removing folder '%s'\n", f.Name)
+		if code := runRemove(append([]string{"-folder", f.Name}, removeArgs...)); code != exitOK {
+			failed = append(failed, f.Name)
+			if code == exitInterrupted {
+				return code
+			}
+			continue
+		}
+		removed = append(removed, f.Name)
+	}
+
+	fmt.Fprintf(textOut, "\nRemoved %d of %d merged folders", len(removed), len(found))
+	if len(skipped) > 0 {
+		fmt.Fprintf(textOut, ", skipped %s", strings.Join(skipped, ", "))
+	}
+	fmt.Fprintln(textOut)
+	if len(failed) > 0 {
+		return fail("failed to remove %s", strings.Join(failed, ", "))
+	}
+	return exitOK
+}
+
+// checkFolderMerged reports whether the branch of every repo in an active folder is merged
+// into its base or gone upstream. Repos whose branch never moved since it was created don't
+// count either way, but at least one repo must be merged.
+func checkFolderMerged(config *Config, cwd, folderName string) (mergedFolder, bool, error) {
+	info := config.Folders[folderName]
+	folder := mergedFolder{Name: folderName, Info: info}
+
+	repos, err := folderRepos(config, cwd, folderName)
+	if err != nil {
+		return folder, false, fmt.Errorf("finding git directories: %w", err)
+	}
+
+	landed := false
+	for _, repo := range repos {
+		branch := info.repoBranch(repo)
+		if _, err := os.Stat(repo.Dir); err != nil || !branchExists(repo.Dir, branch) {
+			folder.Reasons = append(folder.Reasons, repo.Name+": branch deleted")
+			landed = true
+			continue
+		}
+
+		reason := repoMergedReason(repo, branch)
+		if reason == "" {
+			return folder, false, nil
+		}
+		if reason != "unchanged" {
+			landed = true
+		}
+		folder.Reasons = append(folder.Reasons, repo.Name+": "+reason)
+
+		if _, err := os.Stat(repo.Path); err == nil {
+			if risks, err := checkWorktreeRisks(repo.Path); err != nil || !risks.empty() {
+				folder.Risky = true
+			}
+		}
+	}
+	return folder, landed, nil
+}
+
+// repoMergedReason says why a repo's branch is done: "gone upstream", "merged into <base>"
+// or "unchanged" for a branch without commits of its own. Returns "" if it isn't done.
+func repoMergedReason(repo RepoRecord, branch string) string {
+	if upstreamGone(repo.Dir, branch) {
+		return "gone upstream"
+	}
+	if repo.BaseRef == "" || repo.BaseRef == "HEAD" {
+		return ""
+	}
+
+	into := []string{repo.BaseRef}
+	if upstream := upstreamOf(repo.Dir, repo.BaseRef); upstream != "" {
+		into = append(into, upstream)
+	}
+	for _, ref := range into {
+		if !isMergedInto(repo.Dir, branch, ref) {
+			continue
+		}
+		// A branch nobody committed to is trivially "merged"
+		if branchUnchanged(repo.Dir, branch) {
+			return "unchanged"
+		}
+		return "merged into " + ref
+	}
+	return ""
+}
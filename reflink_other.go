@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile would create dst as a copy-on-write clone of src; only Linux is supported
+func cloneFile(src, dst string, perm os.FileMode) error {
+	return errors.New("reflinks are not supported on this platform")
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func runRemove(args []string) int {
+	fs := newFlagSet("remove")
+	dirsFlag := fs.String("dirs", "", "Comma-separated list of directories to remove worktrees from. If not set, uses the repos the folder was created with")
+	folderFlag := fs.String("folder", "", "Folder to remove (defaults to the folder mapped to the branch)")
+	forceFlag := fs.Bool("force", false, "Remove worktrees even if they have uncommitted changes, stashes or unpushed commits")
+	deleteBranchFlag := fs.Bool("delete-branch", false, "Delete the folder's branch in each repo after removing its worktree, if it is merged into its base")
+	forceDeleteBranchFlag := fs.Bool("force-delete-branch", false, "Like -delete-branch, but also delete branches that aren't merged")
+	deleteRemoteFlag := fs.Bool("delete-remote", false, "Also delete the branch on its remote (implies -delete-branch)")
+	jobsFlag := fs.Int("jobs", 0, "Number of repos to process in parallel (default 4, or jobs in config; 1 streams output)")
+	formatFlag := addFormatFlag(fs)
+
+	args, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(args) > 1 {
+		return usageError(fs, "expected at most one branch name")
+	}
+
+	out, err := setupOutput(*formatFlag)
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+	config := ws.config
+
+	deleteBranches := *deleteBranchFlag || *forceDeleteBranchFlag || *deleteRemoteFlag
+	remoteTimeout, err := resolveRemoteTimeout(config, 0)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	var branchName, folderName string
+	if len(args) == 0 && *folderFlag != "" {
+		// The folder alone is enough, its branch comes from the config
+		folderName = *folderFlag
+		info := config.Folders[folderName]
+		if info == nil {
+			return fail("unknown folder '%s'", folderName)
+		}
+		branchName = info.Branch
+	} else if len(args) == 0 {
+		// Interactive selection when no branch name provided
+		folderName, branchName, ok = interactiveSelectMapping(config)
+		if !ok {
+			return exitOK
+		}
+	} else {
+		branchName = args[0]
+
+		// Determine folder name
+		if *folderFlag != "" {
+			// Use specified folder name
+			folderName = *folderFlag
+		} else if existingFolder, ok := findFolderByBranch(config, branchName); ok {
+			// Look up existing active mapping by branch name
+			folderName = existingFolder
+			fmt.Fprintf(textOut, "Using existing mapping: folder '%s' -> branch '%s'\n", folderName, branchName)
+		} else {
+			// Default to branch name as folder name
+			folderName = branchName
+		}
+	}
+
+	// Determine which directories to process
+	var targetDirs []string
+	if *dirsFlag != "" {
+		targetDirs = ws.parseDirs(*dirsFlag)
+	} else if info := config.Folders[folderName]; info != nil && len(info.Repos) > 0 {
+		// Remove exactly the repos the folder was created with
+		targetDirs = recordedDirs(info)
+	} else {
+		// Find all directories with .git subfolder
+		targetDirs, err = findGitDirs(ws.cwd)
+		if err != nil {
+			return fail("finding git directories: %v", err)
+		}
+	}
+
+	if len(targetDirs) == 0 {
+		return fail("no directories found to process")
+	}
+
+	// worktreePath is where a repo's worktree in the folder lives
+	worktreePath := func(dir string) string {
+		if record, ok := findRepoRecord(config.Folders[folderName], dir); ok {
+			return record.Path
+		}
+		return getWorktreePath(dir, folderName)
+	}
+
+	// Never throw away uncommitted or unpushed work without asking
+	forced, code, ok := confirmRiskyRemoval(targetDirs, worktreePath, *forceFlag)
+	if !ok {
+		return code
+	}
+
+	fmt.Fprintf(textOut, "Processing %d directories for branch '%s' (folder: '%s')\n", len(targetDirs), branchName, folderName)
+
+	// Uncommitted files of force-removed worktrees and leftover folder files go here
+	trash := newTrashEntry(ws.cwd, folderName, branchName)
+
+	// Ctrl-C cancels repos that haven't started yet
+	ctx, stop := interruptContext()
+	defer stop()
+	startedAt := time.Now()
+
+	// Process each directory
+	results := runRepos(ctx, targetDirs, resolveJobs(config, *jobsFlag), func(ctx context.Context, dir string, log *repoLog) (string, error) {
+		path := worktreePath(dir)
+		if forced[dir] {
+			n, err := trashDirtyFiles(trash, filepath.Base(dir), path)
+			if err != nil {
+				return "", fmt.Errorf("cannot move uncommitted files to the trash, not removing: %w", err)
+			}
+			if n > 0 {
+				log.Printf("Moved %d uncommitted %s to the trash", n, plural(n, "file", "files"))
+			}
+		}
+		removed, err := removeWorktree(log, dir, path, forced[dir])
+		detail := "removed"
+		if err != nil || !removed {
+			detail = "not present"
+		}
+		if err == nil && deleteBranches {
+			record, ok := findRepoRecord(config.Folders[folderName], dir)
+			branch, baseRef := branchName, record.BaseRef
+			if ok {
+				branch = config.Folders[folderName].repoBranch(record)
+			}
+			var done string
+			done, err = deleteBranch(log, dir, branch, baseRef, branchDeletion{
+				Force:         *forceDeleteBranchFlag,
+				Remote:        *deleteRemoteFlag,
+				Remotes:       resolveRemotes(config, filepath.Base(dir)),
+				RemoteTimeout: remoteTimeout,
+			})
+			if done != "" {
+				detail += ", " + done
+			}
+		}
+		if out != nil && out.stream {
+			out.write(line("repo_result", toJSONRepoResult(repoResult{Dir: dir, Detail: detail, Err: err}, path, "")))
+		}
+		return detail, err
+	})
+	if len(results) > 1 {
+		printSummaryTable(results)
+	}
+
+	// Repos left out by -dirs keep their records, and so does a worktree that is still
+	// there because removing it failed or never started. Any of them keeps the folder active.
+	var remaining []RepoRecord
+	targeted := make(map[string]bool)
+	for _, dir := range targetDirs {
+		targeted[dir] = true
+	}
+	if info := config.Folders[folderName]; info != nil {
+		for _, repo := range info.Repos {
+			if !targeted[repo.Dir] {
+				remaining = append(remaining, repo)
+			}
+		}
+	}
+	for _, r := range results {
+		path := worktreePath(r.Dir)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		record, ok := findRepoRecord(config.Folders[folderName], r.Dir)
+		if !ok {
+			record = RepoRecord{Name: filepath.Base(r.Dir), Dir: r.Dir, Path: path, Branch: branchName}
+		}
+		remaining = append(remaining, record)
+	}
+
+	// Clean up folder directory after all removals
+	folderDir := ws.folderDir(folderName)
+
+	// Clean up symlinks and handle remaining files, unless worktrees remain in there
+	if len(remaining) == 0 {
+		if err := cleanupFolderDir(folderDir, ws.cwd, trash); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: error during cleanup: %v\n", err)
+		}
+	}
+
+	if err := trash.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save trash entry %s: %v\n", trash.ID, err)
+	} else if trash.ID != "" {
+		fmt.Fprintf(textOut, "Moved %d items to the trash (restore with: worktree_plus trash restore %s)\n", len(trash.Items), trash.ID)
+	}
+
+	if len(remaining) > 0 {
+		if info := config.Folders[folderName]; info != nil {
+			info.Repos = remaining
+			info.LastUsed = time.Now()
+		}
+		if ws.save() {
+			fmt.Fprintf(textOut, "Folder '%s' stays active with %s\n", folderName, repoList(remaining))
+		}
+	} else {
+		// Try to remove the folder directory if it's now empty
+		removeEmptyFolderDir(folderDir)
+
+		// Deactivate the folder in config (keeps history)
+		deactivateFolder(config, folderName)
+		if ws.save() {
+			fmt.Fprintf(textOut, "Deactivated folder '%s' (kept in history)\n", folderName)
+		}
+	}
+
+	if out != nil {
+		doc := newRunResult("remove", folderName, branchName, startedAt, results, false)
+		doc.TrashID = trash.ID
+		for i, r := range results {
+			doc.Repos[i].Path = worktreePath(r.Dir)
+		}
+		out.write(doc)
+	}
+
+	return exitCodeFor(ctx, countFailed(results))
+}
+
+// repoList names repos for a message, e.g. "2 repos: api, web"
+func repoList(repos []RepoRecord) string {
+	names := make([]string, len(repos))
+	for i, repo := range repos {
+		names[i] = repo.Name
+	}
+	return fmt.Sprintf("%d %s: %s", len(repos), plural(len(repos), "repo", "repos"), strings.Join(names, ", "))
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+func runRenameBranch(args []string) int {
+	fs := newFlagSet("rename-branch")
+	moveUpstreamFlag := fs.Bool("move-upstream", false, "Also push the branch under the new name, track it and delete the old remote branch")
+	remoteTimeoutFlag := fs.Duration("remote-timeout", 0, "Timeout for each push when moving the upstream (default 15s, or remote_timeout in config)")
+	jobsFlag := fs.Int("jobs", 0, "Number of repos to process in parallel (default 4, or jobs in config; 1 streams output)")
+	formatFlag := addFormatFlag(fs)
+
+	args, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(args) != 2 {
+		return usageError(fs, "expected a folder name and a new branch name")
+	}
+	folderName, newBranch := args[0], args[1]
+
+	out, err := setupOutput(*formatFlag)
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+	config := ws.config
+	remoteTimeout, err := resolveRemoteTimeout(config, *remoteTimeoutFlag)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	target, err := loadFolderTarget(ws, folderName)
+	if err != nil {
+		return fail("%v", err)
+	}
+	info := target.info
+	if info.Sync != nil {
+		return fail("a %s of folder '%s' is in progress; finish it with sync -continue or -abort first", info.Sync.Mode, folderName)
+	}
+	previousBranch := info.spec().String()
+
+	if info.Branch == newBranch {
+		fmt.Fprintf(textOut, "Folder '%s' is already on branch '%s'. Nothing to do.\n", folderName, newBranch)
+		return exitOK
+	}
+
+	// Only the repos on the folder's branch are renamed; per-repo branches stay as they are
+	var dirs, repoNames, others []string
+	oldBranches := make(map[string]string)
+	for _, dir := range target.dirs {
+		repo := target.records[dir]
+		oldBranches[dir] = info.repoBranch(repo)
+		if old := oldBranches[dir]; old != info.Branch && old != newBranch {
+			others = append(others, fmt.Sprintf("%s (%s)", repo.Name, old))
+			continue
+		}
+		dirs = append(dirs, dir)
+		repoNames = append(repoNames, repo.Name)
+	}
+
+	if conflictFolder, _ := checkBranchConflict(config, folderName, branchSpec{anyRepo: newBranch}, repoNames); conflictFolder != "" {
+		return fail("branch '%s' is already active in folder '%s'", newBranch, conflictFolder)
+	}
+
+	// Refuse up front rather than renaming some repos and rolling them back
+	var problems []string
+	for _, dir := range dirs {
+		repo := target.records[dir]
+		old := oldBranches[dir]
+		switch {
+		case old == newBranch:
+		case branchExists(dir, newBranch):
+			problems = append(problems, fmt.Sprintf("  [%s] branch '%s' already exists", repo.Name, newBranch))
+		case !branchExists(dir, old):
+			problems = append(problems, fmt.Sprintf("  [%s] branch '%s' does not exist", repo.Name, old))
+		}
+	}
+	if len(problems) > 0 {
+		printProblems("Cannot rename the branch in every repository:", problems)
+		return fail("refusing to rename the branch of folder '%s'; nothing was changed", folderName)
+	}
+
+	fmt.Fprintf(textOut, "Renaming branch '%s' of folder '%s' to '%s' in %d repositories\n", info.Branch, folderName, newBranch, len(dirs))
+	if len(others) > 0 {
+		fmt.Fprintf(textOut, "Leaving the other branches as they are: %s\n", strings.Join(others, ", "))
+	}
+
+	// Ctrl-C cancels repos that haven't started yet and rolls back the rest
+	ctx, stop := interruptContext()
+	defer stop()
+	startedAt := time.Now()
+	jobs := resolveJobs(config, *jobsFlag)
+
+	var renamedMu sync.Mutex
+	renamed := make(map[string]bool)
+	results := runRepos(ctx, dirs, jobs, func(ctx context.Context, dir string, log *repoLog) (string, error) {
+		old := oldBranches[dir]
+		if old == newBranch {
+			log.Printf("Already on '%s'", newBranch)
+			return "already renamed", nil
+		}
+		if err := renameBranch(log, dir, old, newBranch); err != nil {
+			return "", err
+		}
+		renamedMu.Lock()
+		renamed[dir] = true
+		renamedMu.Unlock()
+		return fmt.Sprintf("renamed from %s", old), nil
+	})
+	if len(results) > 1 {
+		printSummaryTable(results)
+	}
+
+	emitResult := func(rolledBack bool) {
+		if out == nil {
+			return
+		}
+		doc := newRunResult("rename-branch", folderName, newBranch, startedAt, results, rolledBack)
+		for i, r := range results {
+			doc.Repos[i].Path = target.records[r.Dir].Path
+		}
+		out.write(doc)
+	}
+
+	failed := countFailed(results)
+	if failed > 0 || ctx.Err() != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintln(os.Stderr, "\nInterrupted, rolling back all repositories")
+		} else {
+			fmt.Fprintf(os.Stderr, "\n%d of %d repositories failed, rolling back all repositories\n", failed, len(dirs))
+		}
+		var renamedDirs []string
+		for _, dir := range dirs {
+			if renamed[dir] {
+				renamedDirs = append(renamedDirs, dir)
+			}
+		}
+		rollback := runRepos(context.Background(), renamedDirs, jobs, func(ctx context.Context, dir string, log *repoLog) (string, error) {
+			if err := renameBranch(log, dir, newBranch, oldBranches[dir]); err != nil {
+				return "", err
+			}
+			return "renamed back to " + oldBranches[dir], nil
+		})
+		if len(rollback) > 1 {
+			printSummaryTable(rollback)
+		}
+		if failed := countFailed(rollback); failed > 0 {
+			fmt.Fprintf(os.Stderr, "Rollback failed in %d repositories, see above\n", failed)
+		} else {
+			fmt.Fprintln(textOut, "Rolled back. Config left unchanged.")
+		}
+		emitResult(true)
+		if ctx.Err() != nil {
+			return exitInterrupted
+		}
+		return exitFailure
+	}
+
+	// Git and the config agree again
+	var records []RepoRecord
+	for _, dir := range dirs {
+		repo := target.records[dir]
+		repo.Branch = newBranch
+		records = append(records, repo)
+	}
+	renameFolderBranch(config, folderName, info.Branch, newBranch)
+	recordRepos(config, folderName, records)
+	if ws.save() {
+		fmt.Fprintf(textOut, "\nFolder '%s' is now on branch '%s' (was '%s')\n", folderName, info.spec(), previousBranch)
+	}
+
+	if *moveUpstreamFlag {
+		fmt.Fprintln(textOut, "\nMoving upstream branches")
+		moved := runRepos(ctx, dirs, jobs, func(ctx context.Context, dir string, log *repoLog) (string, error) {
+			return moveUpstream(log, dir, oldBranches[dir], newBranch, remoteTimeout)
+		})
+		if len(moved) > 1 {
+			printSummaryTable(moved)
+		}
+		for i := range results {
+			results[i].Detail += ", " + moved[i].Detail
+			results[i].Err = moved[i].Err
+		}
+		failed = countFailed(moved)
+	}
+
+	emitResult(false)
+	return exitCodeFor(ctx, failed)
+}
+
+// renameBranch renames a local branch, which also moves its upstream setting and reflog
+func renameBranch(log *repoLog, dir, oldName, newName string) error {
+	log.Printf("Renaming branch '%s' to '%s'", oldName, newName)
+	cmd := exec.Command("git", "branch", "-m", oldName, newName)
+	cmd.Dir = dir
+	cmd.Stdout = log.Stdout()
+	cmd.Stderr = log.Stderr()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git branch -m failed: %w", err)
+	}
+	return nil
+}
+
+// moveUpstream pushes a renamed branch under its new name and tracks it. The remote branch
+// it tracked before is deleted only if it had the old name; one named differently, like the
+// base, is left alone. Branches without an upstream are left alone too.
+func moveUpstream(log *repoLog, dir, oldName, newName string, timeout time.Duration) (string, error) {
+	upstream := upstreamOf(dir, newName)
+	remote := upstreamRemote(dir, newName)
+	if upstream == "" || remote == "" || remote == "." {
+		log.Printf("No upstream to move")
+		return "no upstream", nil
+	}
+	if upstream == remote+"/"+newName {
+		return "upstream already " + upstream, nil
+	}
+
+	log.Printf("Pushing '%s' to '%s'", newName, remote)
+	if _, err := runGitTimeout(dir, timeout, "push", "--set-upstream", remote, newName); err != nil {
+		return "", err
+	}
+	if upstream != remote+"/"+oldName {
+		log.Printf("Left '%s' alone, it isn't named like the branch", upstream)
+		return fmt.Sprintf("upstream set to %s/%s", remote, newName), nil
+	}
+	log.Printf("Deleting remote branch '%s'", upstream)
+	if _, err := runGitTimeout(dir, timeout, "push", remote, "--delete", oldName); err != nil {
+		return "upstream " + remote + "/" + newName, err
+	}
+	return fmt.Sprintf("upstream moved to %s/%s", remote, newName), nil
+}
+
+func runRenameFolder(args []string) int {
+	fs := newFlagSet("rename-folder")
+	jobsFlag := fs.Int("jobs", 0, "Number of repos to process in parallel (default 4, or jobs in config; 1 streams output)")
+	formatFlag := addFormatFlag(fs)
+
+	args, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(args) != 2 {
+		return usageError(fs, "expected the current and the new folder name")
+	}
+	oldName, newName := args[0], args[1]
+	if newName == "" || newName == "." || newName == ".." || strings.ContainsAny(newName, `/\`) {
+		return usageError(fs, "invalid folder name '%s'", newName)
+	}
+
+	out, err := setupOutput(*formatFlag)
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+	config := ws.config
+
+	info := config.Folders[oldName]
+	if info == nil {
+		return fail("unknown folder '%s'", oldName)
+	}
+	if oldName == newName {
+		fmt.Fprintf(textOut, "Folder '%s' already has that name. Nothing to do.\n", oldName)
+		return exitOK
+	}
+	if config.Folders[newName] != nil {
+		return fail("folder '%s' already exists in the history; remove it from %s first or pick another name", newName, configFileName)
+	}
+	oldDir, newDir := ws.folderDir(oldName), ws.folderDir(newName)
+	if _, err := os.Lstat(newDir); err == nil {
+		return fail("%s already exists", newDir)
+	}
+
+	// An inactive folder has no worktrees, only its history moves
+	if !info.IsActive {
+		delete(config.Folders, oldName)
+		config.Folders[newName] = info
+		if !ws.save() {
+			return exitFailure
+		}
+		fmt.Fprintf(textOut, "Renamed inactive folder '%s' to '%s'\n", oldName, newName)
+		return exitOK
+	}
+
+	target, err := loadFolderTarget(ws, oldName)
+	if err != nil {
+		return fail("%v", err)
+	}
+	newPaths := make(map[string]string)
+	for _, dir := range target.dirs {
+		newPaths[dir] = getWorktreePath(dir, newName)
+	}
+
+	if err := os.MkdirAll(newDir, 0755); err != nil {
+		return fail("creating %s: %v", newDir, err)
+	}
+
+	fmt.Fprintf(textOut, "Moving %d worktrees of folder '%s' to '%s'\n", len(target.dirs), oldName, newName)
+
+	// Ctrl-C cancels repos that haven't started yet and moves the rest back
+	ctx, stop := interruptContext()
+	defer stop()
+	startedAt := time.Now()
+	jobs := resolveJobs(config, *jobsFlag)
+
+	var movedMu sync.Mutex
+	moved := make(map[string]bool)
+	results := runRepos(ctx, target.dirs, jobs, func(ctx context.Context, dir string, log *repoLog) (string, error) {
+		oldPath := target.records[dir].Path
+		if _, err := os.Stat(oldPath); os.IsNotExist(err) {
+			log.Printf("Worktree does not exist at %s", oldPath)
+			return "not present", nil
+		}
+		if err := moveWorktree(log, dir, oldPath, newPaths[dir]); err != nil {
+			return "", err
+		}
+		movedMu.Lock()
+		moved[dir] = true
+		movedMu.Unlock()
+		return "moved to " + newPaths[dir], nil
+	})
+	if len(results) > 1 {
+		printSummaryTable(results)
+	}
+
+	emitResult := func(rolledBack bool) {
+		if out == nil {
+			return
+		}
+		doc := newRunResult("rename-folder", newName, info.Branch, startedAt, results, rolledBack)
+		for i, r := range results {
+			doc.Repos[i].Path = newPaths[r.Dir]
+			if rolledBack {
+				doc.Repos[i].Path = target.records[r.Dir].Path
+			}
+		}
+		out.write(doc)
+	}
+
+	failed := countFailed(results)
+	if failed > 0 || ctx.Err() != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintln(os.Stderr, "\nInterrupted, moving all worktrees back")
+		} else {
+			fmt.Fprintf(os.Stderr, "\n%d of %d repositories failed, moving all worktrees back\n", failed, len(target.dirs))
+		}
+		var dirs []string
+		for _, dir := range target.dirs {
+			if moved[dir] {
+				dirs = append(dirs, dir)
+			}
+		}
+		rollback := runRepos(context.Background(), dirs, jobs, func(ctx context.Context, dir string, log *repoLog) (string, error) {
+			if err := moveWorktree(log, dir, newPaths[dir], target.records[dir].Path); err != nil {
+				return "", err
+			}
+			return "moved back", nil
+		})
+		if len(rollback) > 1 {
+			printSummaryTable(rollback)
+		}
+		removeEmptyFolderDir(newDir)
+		if failed := countFailed(rollback); failed > 0 {
+			fmt.Fprintf(os.Stderr, "Rollback failed in %d repositories, see above\n", failed)
+		} else {
+			fmt.Fprintln(textOut, "Rolled back. Config left unchanged.")
+		}
+		emitResult(true)
+		if ctx.Err() != nil {
+			return exitInterrupted
+		}
+		return exitFailure
+	}
+
+	// The config entry moves with its history; only the worktree paths change
+	for i := range info.Repos {
+		if path, ok := newPaths[info.Repos[i].Dir]; ok {
+			info.Repos[i].Path = path
+		}
+	}
+	delete(config.Folders, oldName)
+	config.Folders[newName] = info
+	ws.save()
+
+	if err := moveFolderLeftovers(oldDir, newDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	if err := symlinkRootFiles(ws.cwd, newDir, target.dirs); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to symlink some root files: %v\n", err)
+	}
+	fmt.Fprintf(textOut, "\nRenamed folder '%s' to '%s'\n", oldName, newName)
+
+	emitResult(false)
+	return exitOK
+}
+
+// moveWorktree moves a worktree with git, so that the repository keeps track of it
+func moveWorktree(log *repoLog, dir, oldPath, newPath string) error {
+	log.Printf("Moving worktree %s to %s", oldPath, newPath)
+	cmd := exec.Command("git", "worktree", "move", oldPath, newPath)
+	cmd.Dir = dir
+	cmd.Stdout = log.Stdout()
+	cmd.Stderr = log.Stderr()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git worktree move failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+func runReopen(args []string) int {
+	fs := newFlagSet("reopen")
+	fetchTimeoutFlag := fs.Duration("fetch-timeout", defaultFetchTimeout, "Timeout for each git fetch")
+	offlineFlag := fs.Bool("offline", false, "Don't contact remotes; only use locally known remote branches")
+	remoteTimeoutFlag := fs.Duration("remote-timeout", 0, "Timeout for each remote branch lookup before falling back to local refs (default 15s, or remote_timeout in config)")
+	jobsFlag := fs.Int("jobs", 0, "Number of repos to process in parallel (default 4, or jobs in config; 1 streams output)")
+	formatFlag := addFormatFlag(fs)
+
+	args, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(args) != 1 {
+		return usageError(fs, "expected exactly one folder name")
+	}
+	folderName := args[0]
+
+	out, err := setupOutput(*formatFlag)
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+	config := ws.config
+
+	offline := *offlineFlag || config.Offline
+	remoteTimeout, err := resolveRemoteTimeout(config, *remoteTimeoutFlag)
+	if err != nil {
+		return fail("%v", err)
+	}
+	if err := validateIgnoredRules(config); err != nil {
+		return fail("%v", err)
+	}
+
+	info := config.Folders[folderName]
+	if info == nil {
+		return fail("unknown folder '%s'", folderName)
+	}
+	if info.IsActive {
+		fmt.Fprintf(textOut, "Folder '%s' is already active on branch '%s'. Nothing to do.\n", folderName, info.Branch)
+		return exitOK
+	}
+	branchName, spec := info.Branch, info.spec()
+
+	// Reopen the repo set the folder last had; older entries fall back to every repo
+	previous, err := folderRepos(config, ws.cwd, folderName)
+	if err != nil {
+		return fail("finding git directories: %v", err)
+	}
+	if len(previous) == 0 {
+		return fail("no repos recorded for folder '%s'", folderName)
+	}
+	targetDirs := make([]string, len(previous))
+	repoNames := make([]string, len(previous))
+	repoBranches := make(map[string]string)
+	repoSpec := make(branchSpec)
+	for i, repo := range previous {
+		targetDirs[i], repoNames[i] = repo.Dir, repo.Name
+		repoBranches[repo.Dir] = info.repoBranch(repo)
+		repoSpec[repo.Name] = repoBranches[repo.Dir]
+	}
+
+	if conflictFolder, conflictBranch := checkBranchConflict(config, folderName, repoSpec, repoNames); conflictFolder != "" {
+		code := fail("branch '%s' is already active in folder '%s'", conflictBranch, conflictFolder)
+		fmt.Fprintf(os.Stderr, "Remove the existing worktrees first with: worktree_plus remove -folder %s\n", conflictFolder)
+		return code
+	}
+
+	if err := requireBranchesFree(targetDirs, repoBranches, folderName); err != nil {
+		return fail("cannot reopen folder '%s' on branch '%s': %v; check out another branch there first", folderName, spec, err)
+	}
+
+	fmt.Fprintf(textOut, "Reopening folder '%s' on branch '%s' in %d directories\n", folderName, spec, len(targetDirs))
+
+	// Ctrl-C cancels repos that haven't started yet
+	ctx, stop := interruptContext()
+	defer stop()
+
+	var createdMu sync.Mutex
+	created := make(map[string]createResult)
+	startedAt := time.Now()
+
+	results := runRepos(ctx, targetDirs, resolveJobs(config, *jobsFlag), func(ctx context.Context, dir string, log *repoLog) (string, error) {
+		if _, err := os.Stat(dir); err != nil {
+			log.Printf("Skipping, the main checkout no longer exists")
+			return "skipped: repo no longer exists", nil
+		}
+		result, err := createWorktree(log, dir, folderName, repoBranches[dir], createOptions{
+			Remotes:      resolveRemotes(config, filepath.Base(dir)),
+			Lookup:       remoteLookup{Offline: offline, Timeout: remoteTimeout},
+			FetchTimeout: *fetchTimeoutFlag,
+			Ignored:      resolveIgnoredRules(config, filepath.Base(dir)),
+			ExistingOnly: true,
+		})
+		detail := result.summary()
+		if errors.Is(err, errBranchNotFound) {
+			log.Printf("Skipping, branch '%s' no longer exists", repoBranches[dir])
+			detail, err = "skipped: "+err.Error(), nil
+		} else if err == nil {
+			createdMu.Lock()
+			created[dir] = result
+			createdMu.Unlock()
+		}
+		if out != nil && out.stream {
+			out.write(line("repo_result", toJSONRepoResult(repoResult{Dir: dir, Detail: detail, Err: err}, result.Path, result.BaseRef)))
+		}
+		return detail, err
+	})
+	if len(results) > 1 {
+		printSummaryTable(results)
+	}
+
+	// Record the repos that came back, keeping the base each was originally created from
+	var records []RepoRecord
+	var restored []string
+	for _, repo := range previous {
+		result, ok := created[repo.Dir]
+		if !ok {
+			continue
+		}
+		if repo.BaseRef != "" {
+			result.BaseRef = repo.BaseRef
+			created[repo.Dir] = result
+		}
+		records = append(records, RepoRecord{Name: repo.Name, Dir: repo.Dir, Path: result.Path, Branch: result.Branch, BaseRef: result.BaseRef})
+		restored = append(restored, repo.Name)
+	}
+
+	if len(records) == 0 {
+		removeEmptyFolderDir(ws.folderDir(folderName))
+		fmt.Fprintf(textOut, "\nNo repos restored, folder '%s' stays inactive\n", folderName)
+	} else {
+		touchFolder(config, folderName, spec)
+		recordRepos(config, folderName, records)
+		ws.save()
+
+		if err := symlinkRootFiles(ws.cwd, ws.folderDir(folderName), targetDirs); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to symlink some root files: %v\n", err)
+		}
+		fmt.Fprintf(textOut, "\nRestored %d of %d repos in folder '%s': %s\n", len(restored), len(previous), folderName, strings.Join(restored, ", "))
+	}
+
+	if out != nil {
+		doc := newRunResult("reopen", folderName, branchName, startedAt, results, false)
+		for i, r := range results {
+			doc.Repos[i].Path, doc.Repos[i].BaseRef = created[r.Dir].Path, created[r.Dir].BaseRef
+		}
+		out.write(doc)
+	}
+
+	failed := countFailed(results)
+	if len(records) == 0 && failed == 0 && ctx.Err() == nil {
+		return exitFailure
+	}
+	return exitCodeFor(ctx, failed)
+}
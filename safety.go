@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// worktreeRisks is the work that could be lost by removing a worktree
+type worktreeRisks struct {
+	Changed  []string // modified, staged or untracked files
+	Stashes  []string // stash entries made on the worktree's branch
+	Unpushed int      // commits on HEAD that aren't on any remote
+}
+
+// empty reports whether nothing would be lost
+func (r worktreeRisks) empty() bool {
+	return len(r.Changed) == 0 && len(r.Stashes) == 0 && r.Unpushed == 0
+}
+
+// describe lists the findings, one per line
+func (r worktreeRisks) describe() []string {
+	var lines []string
+	if n := len(r.Changed); n > 0 {
+		lines = append(lines, fmt.Sprintf("%d uncommitted %s: %s", n, plural(n, "file", "files"), summarizeList(r.Changed, 5)))
+	}
+	for _, stash := range r.Stashes {
+		lines = append(lines, "stash "+stash)
+	}
+	if r.Unpushed > 0 {
+		lines = append(lines, fmt.Sprintf("%d %s not on any remote", r.Unpushed, plural(r.Unpushed, "commit", "commits")))
+	}
+	return lines
+}
+
+// checkWorktreeRisks inspects a worktree for uncommitted changes, stashes and unpushed commits
+func checkWorktreeRisks(worktreePath string) (worktreeRisks, error) {
+	var risks worktreeRisks
+
+	changed, err := changedFiles(worktreePath)
+	if err != nil {
+		return risks, err
+	}
+	risks.Changed = changed
+
+	if branch := currentBranch(worktreePath); branch != "" {
+		risks.Stashes = branchStashes(worktreePath, branch)
+	}
+
+	risks.Unpushed, err = unpushedCommits(worktreePath)
+	if err != nil {
+		return risks, err
+	}
+
+	return risks, nil
+}
+
+// branchStashes returns the stash entries created while the branch was checked out.
+// Stashes are shared by all worktrees of a repo, so they are matched by branch name.
+func branchStashes(repoDir, branch string) []string {
+	cmd := exec.Command("git", "stash", "list", "--format=%gd %gs")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+
+	var stashes []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		ref, subject, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(subject, "WIP on "+branch+":") || strings.HasPrefix(subject, "On "+branch+":") {
+			stashes = append(stashes, ref+" ("+subject+")")
+		}
+	}
+	return stashes
+}
+
+// unpushedCommits counts the commits on HEAD that no remote-tracking branch contains.
+// In a repo without remotes, commits that no other local branch contains are counted instead.
+func unpushedCommits(repoDir string) (int, error) {
+	args := []string{"rev-list", "--count", "HEAD", "--not", "--remotes"}
+	if len(listRemotes(repoDir)) == 0 {
+		args = []string{"rev-list", "--count", "HEAD", "--not"}
+		if branch := currentBranch(repoDir); branch != "" {
+			// --branches matches exclude patterns without the refs/heads/ prefix
+			args = append(args, "--exclude="+branch)
+		}
+		args = append(args, "--branches")
+	}
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("git rev-list failed: %w", err)
+	}
+	return strconv.Atoi(strings.TrimSpace(string(output)))
+}
+
+// confirmRiskyRemoval checks every worktree that is about to be removed and lists
+// whatever work would be lost. Risky worktrees are only removed with -force or after
+// the user confirms; without a terminal to ask on, the removal is refused. Returns the
+// worktrees that need a forced removal. When it returns false, the command should exit
+// with the returned code.
+func confirmRiskyRemoval(dirs []string, worktreePath func(string) string, force bool) (map[string]bool, int, bool) {
+	forced := make(map[string]bool)
+	var findings []string
+
+	for _, dir := range dirs {
+		path := worktreePath(dir)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		name := filepath.Base(dir)
+		risks, err := checkWorktreeRisks(path)
+		if err != nil {
+			findings = append(findings, fmt.Sprintf("  [%s] cannot check for uncommitted work: %v", name, err))
+			forced[dir] = true
+			continue
+		}
+		if risks.empty() {
+			continue
+		}
+		for _, line := range risks.describe() {
+			findings = append(findings, fmt.Sprintf("  [%s] %s", name, line))
+		}
+		forced[dir] = true
+	}
+
+	if len(findings) == 0 {
+		return forced, exitOK, true
+	}
+
+	fmt.Fprintln(textOut, "\nThe following worktrees contain work that may be lost:")
+	for _, line := range findings {
+		fmt.Fprintln(textOut, line)
+	}
+
+	if force {
+		fmt.Fprintln(textOut, "Removing anyway (-force)")
+		return forced, exitOK, true
+	}
+
+	if !isTerminal(os.Stdin) {
+		fmt.Fprintln(textOut)
+		return nil, fail("refusing to remove worktrees with uncommitted or unpushed work; use -force to remove them anyway"), false
+	}
+
+	idx := runSelect("Remove these worktrees anyway?", []string{
+		"Cancel",
+		"Remove anyway (uncommitted files are moved to the trash)",
+	})
+	if idx != 1 {
+		fmt.Fprintln(textOut, "Cancelled.")
+		return nil, exitOK, false
+	}
+	return forced, exitOK, true
+}
+
+// plural picks the singular or plural form for n
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// summarizeList joins the first max items and says how many more there are
+func summarizeList(items []string, max int) string {
+	if len(items) <= max {
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(items[:max], ", "), len(items)-max)
+}
+
+// requireClean lists the worktrees with uncommitted changes, which a switch, rebase or
+// merge would carry along or stop on, and fails if there are any. Missing worktrees are
+// skipped.
+func requireClean(repos []RepoRecord) error {
+	var dirty []string
+	for _, repo := range repos {
+		if _, err := os.Stat(repo.Path); err != nil {
+			continue
+		}
+		files, err := changedFiles(repo.Path)
+		if err != nil {
+			dirty = append(dirty, fmt.Sprintf("  [%s] cannot check for changes: %v", repo.Name, err))
+		} else if n := len(files); n > 0 {
+			dirty = append(dirty, fmt.Sprintf("  [%s] %d uncommitted %s: %s", repo.Name, n, plural(n, "file", "files"), summarizeList(files, 5)))
+		}
+	}
+	if len(dirty) == 0 {
+		return nil
+	}
+	printProblems("The following worktrees have uncommitted changes:", dirty)
+	return fmt.Errorf("%d %s uncommitted changes", len(dirty), plural(len(dirty), "worktree has", "worktrees have"))
+}
+
+// requireBranchesFree lists the repos whose branch is checked out in a worktree other than
+// the folder's own, e.g. main in the main checkout, and fails if there are any. Git
+// refuses to check a branch out twice.
+func requireBranchesFree(dirs []string, branches map[string]string, folderName string) error {
+	var busy []string
+	for _, dir := range dirs {
+		path := worktreeOf(dir, branches[dir])
+		if path == "" || samePath(path, getWorktreePath(dir, folderName)) {
+			continue
+		}
+		busy = append(busy, fmt.Sprintf("  [%s] '%s' is checked out at %s", filepath.Base(dir), branches[dir], path))
+	}
+	if len(busy) == 0 {
+		return nil
+	}
+	printProblems("The following branches are checked out in another worktree:", busy)
+	return fmt.Errorf("%d %s checked out elsewhere", len(busy), plural(len(busy), "branch is", "branches are"))
+}
+
+// printProblems prints per-repo problems under a heading, followed by a blank line
+func printProblems(heading string, lines []string) {
+	fmt.Fprintln(textOut, heading)
+	for _, line := range lines {
+		fmt.Fprintln(textOut, line)
+	}
+	fmt.Fprintln(textOut)
+}
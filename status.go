@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// repoStatus is the state of one repository's worktree (or main checkout)
+type repoStatus struct {
+	Name           string
+	Path           string
+	Present        bool
+	Branch         string // checked out branch, empty if HEAD is detached
+	ExpectedBranch string // branch the config says should be checked out
+	Head           string
+	Dirty          int
+	Upstream       string
+	UpstreamAhead  int
+	UpstreamBehind int
+	BaseRef        string
+	BaseAhead      int
+	BaseBehind     int
+	Err            error
+}
+
+// branchMismatch reports whether the worktree is on a different branch than the config says
+func (s repoStatus) branchMismatch() bool {
+	return s.Present && s.ExpectedBranch != "" && s.Branch != s.ExpectedBranch
+}
+
+// folderStatus is the state of every repo in a folder
+type folderStatus struct {
+	Name     string
+	Branch   string // the folder's branch, or its per-repo branches
+	IsActive bool
+	Repos    []repoStatus
+}
+
+// collectRepoStatus inspects the checkout at path
+func collectRepoStatus(name, path, expectedBranch, baseRef string) repoStatus {
+	status := repoStatus{Name: name, Path: path, ExpectedBranch: expectedBranch, BaseRef: baseRef}
+
+	if _, err := os.Stat(path); err != nil {
+		return status
+	}
+	status.Present = true
+
+	head, err := headCommit(path)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	status.Head = head
+	status.Branch = currentBranch(path)
+
+	files, err := changedFiles(path)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	status.Dirty = len(files)
+
+	if status.Upstream = upstreamBranch(path); status.Upstream != "" {
+		status.UpstreamAhead, status.UpstreamBehind, _ = aheadBehind(path, status.Upstream)
+	}
+	if baseRef != "" {
+		if status.BaseAhead, status.BaseBehind, err = aheadBehind(path, baseRef); err != nil {
+			status.Err = err
+		}
+	}
+
+	return status
+}
+
+// collectFolderStatus inspects every repo of a folder
+func collectFolderStatus(config *Config, cwd, folderName string) (folderStatus, error) {
+	status := folderStatus{Name: folderName}
+	info := config.Folders[folderName]
+	if info != nil {
+		status.Branch = info.spec().String()
+		status.IsActive = info.IsActive
+	}
+
+	repos, err := folderRepos(config, cwd, folderName)
+	if err != nil {
+		return status, err
+	}
+	for _, repo := range repos {
+		expected := ""
+		if info != nil {
+			expected = info.repoBranch(repo)
+		}
+		status.Repos = append(status.Repos, collectRepoStatus(repo.Name, repo.Path, expected, repo.BaseRef))
+	}
+	return status, nil
+}
+
+// collectMainStatus inspects the main checkouts in the workspace
+func collectMainStatus(cwd string) ([]repoStatus, error) {
+	dirs, err := findGitDirs(cwd)
+	if err != nil {
+		return nil, err
+	}
+	var repos []repoStatus
+	for _, dir := range dirs {
+		repos = append(repos, collectRepoStatus(filepath.Base(dir), dir, "", ""))
+	}
+	return repos, nil
+}
+
+// printRepoStatusTable prints one row per repo, followed by any branch mismatches and errors
+func printRepoStatusTable(repos []repoStatus) {
+	rows := [][]string{{"REPO", "WORKTREE", "HEAD", "CHANGES", "UPSTREAM", "BASE"}}
+	var notes []string
+	for _, r := range repos {
+		if !r.Present {
+			rows = append(rows, []string{r.Name, "missing", "-", "-", "-", "-"})
+			continue
+		}
+
+		head := r.Head
+		if r.Branch != "" {
+			head = r.Branch + " @ " + r.Head
+		} else if r.Head != "" {
+			head = "detached @ " + r.Head
+		}
+
+		changes := "clean"
+		if r.Dirty > 0 {
+			changes = fmt.Sprintf("%d dirty", r.Dirty)
+		}
+
+		upstream := "-"
+		if r.Upstream != "" {
+			upstream = fmt.Sprintf("%s +%d/-%d", r.Upstream, r.UpstreamAhead, r.UpstreamBehind)
+		}
+
+		base := "-"
+		if r.BaseRef != "" && r.Err == nil {
+			base = fmt.Sprintf("%s +%d/-%d", r.BaseRef, r.BaseAhead, r.BaseBehind)
+		}
+
+		rows = append(rows, []string{r.Name, "present", head, changes, upstream, base})
+
+		if r.branchMismatch() {
+			notes = append(notes, fmt.Sprintf("%s: on '%s', expected branch '%s'", r.Name, headLabel(r), r.ExpectedBranch))
+		}
+		if r.Err != nil {
+			notes = append(notes, fmt.Sprintf("%s: %v", r.Name, r.Err))
+		}
+	}
+
+	printTable(rows, "  ")
+	for _, note := range notes {
+		fmt.Fprintf(textOut, "  ! %s\n", note)
+	}
+}
+
+// headLabel names what a checkout is on: its branch, or the detached commit
+func headLabel(r repoStatus) string {
+	if r.Branch != "" {
+		return r.Branch
+	}
+	return "detached " + r.Head
+}
+
+// printTable prints rows as left-aligned columns, each line starting with indent
+func printTable(rows [][]string, indent string) {
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+
+	for _, row := range rows {
+		var b strings.Builder
+		b.WriteString(indent)
+		for i, cell := range row {
+			if i == len(row)-1 {
+				b.WriteString(cell)
+			} else {
+				fmt.Fprintf(&b, "%-*s  ", widths[i], cell)
+			}
+		}
+		fmt.Fprintln(textOut, b.String())
+	}
+}
+
+func runStatusCommand(args []string) int {
+	fs := newFlagSet("status")
+	mainFlag := fs.Bool("main", false, "Also show the main checkouts")
+	formatFlag := addFormatFlag(fs)
+
+	args, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+
+	out, err := setupOutput(*formatFlag)
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	if err := reportStatus(ws.config, ws.cwd, args, *mainFlag, out); err != nil {
+		return fail("%v", err)
+	}
+	return exitOK
+}
+
+// reportStatus reports the status of the named folders, or of every active folder.
+// out is nil for text output.
+func reportStatus(config *Config, cwd string, folderNames []string, includeMain bool, out *jsonWriter) error {
+	if len(folderNames) == 0 {
+		for _, f := range getRecentFolders(config) {
+			if f.IsActive {
+				folderNames = append(folderNames, f.Name)
+			}
+		}
+	}
+
+	for _, name := range folderNames {
+		if _, ok := config.Folders[name]; !ok {
+			return fmt.Errorf("unknown folder '%s'", name)
+		}
+	}
+
+	if len(folderNames) == 0 && !includeMain && out == nil {
+		fmt.Fprintln(textOut, "No active folders.")
+		return nil
+	}
+
+	var folders []folderStatus
+	for _, name := range folderNames {
+		status, err := collectFolderStatus(config, cwd, name)
+		if err != nil {
+			return err
+		}
+		if out != nil && out.stream {
+			out.write(line("folder_status", toJSONFolderStatus(status)))
+		}
+		folders = append(folders, status)
+	}
+
+	var mainRepos []repoStatus
+	if includeMain {
+		var err error
+		if mainRepos, err = collectMainStatus(cwd); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case out != nil && out.stream:
+		if includeMain {
+			out.write(jsonMainStatus{jsonHeader: header("main_status"), Repos: toJSONRepoStatuses(mainRepos)})
+		}
+		return nil
+	case out != nil:
+		doc := jsonStatus{jsonHeader: header("status"), Folders: []jsonFolderStatus{}}
+		for _, status := range folders {
+			doc.Folders = append(doc.Folders, toJSONFolderStatus(status))
+		}
+		if includeMain {
+			doc.Main = toJSONRepoStatuses(mainRepos)
+		}
+		out.write(doc)
+		return nil
+	}
+
+	for i, status := range folders {
+		if i > 0 {
+			fmt.Fprintln(textOut)
+		}
+		state := "inactive"
+		if status.IsActive {
+			state = "active"
+		}
+		fmt.Fprintf(textOut, "Folder '%s' (branch '%s', %s)\n", status.Name, status.Branch, state)
+		printRepoStatusTable(status.Repos)
+	}
+
+	if includeMain {
+		if len(folders) > 0 {
+			fmt.Fprintln(textOut)
+		}
+		fmt.Fprintln(textOut, "Main checkouts")
+		printRepoStatusTable(mainRepos)
+	}
+
+	return nil
+}
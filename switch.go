@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+func runSwitch(args []string) int {
+	fs := newFlagSet("switch")
+	fromFlag := fs.String("from", "", "Ref that new branches start from (e.g. origin/main, a tag or a commit). Overrides base_ref in the config.")
+	fetchTimeoutFlag := fs.Duration("fetch-timeout", defaultFetchTimeout, "Timeout for each git fetch")
+	offlineFlag := fs.Bool("offline", false, "Don't contact remotes; only use locally known remote branches")
+	remoteTimeoutFlag := fs.Duration("remote-timeout", 0, "Timeout for each remote branch lookup before falling back to local refs (default 15s, or remote_timeout in config)")
+	jobsFlag := fs.Int("jobs", 0, "Number of repos to process in parallel (default 4, or jobs in config; 1 streams output)")
+	formatFlag := addFormatFlag(fs)
+
+	args, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(args) != 2 {
+		return usageError(fs, "expected a folder name and a branch name")
+	}
+	folderName, branchName := args[0], args[1]
+
+	out, err := setupOutput(*formatFlag)
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+	config := ws.config
+
+	offline := *offlineFlag || config.Offline
+	remoteTimeout, err := resolveRemoteTimeout(config, *remoteTimeoutFlag)
+	if err != nil {
+		return fail("%v", err)
+	}
+	if err := validateIgnoredRules(config); err != nil {
+		return fail("%v", err)
+	}
+
+	info := config.Folders[folderName]
+	if info == nil {
+		return fail("unknown folder '%s'", folderName)
+	}
+	if !info.IsActive {
+		return fail("folder '%s' is not active (reopen it with: worktree_plus reopen %s)", folderName, folderName)
+	}
+	if info.Branch == branchName && len(info.Branches) == 0 {
+		fmt.Fprintf(textOut, "Folder '%s' is already on branch '%s'. Nothing to do.\n", folderName, branchName)
+		return exitOK
+	}
+	previousBranch := info.spec().String()
+
+	repos, err := folderRepos(config, ws.cwd, folderName)
+	if err != nil {
+		return fail("finding git directories: %v", err)
+	}
+	if len(repos) == 0 {
+		return fail("no repos recorded for folder '%s'", folderName)
+	}
+	repoNames := make([]string, len(repos))
+	for i, repo := range repos {
+		repoNames[i] = repo.Name
+	}
+
+	if conflictFolder, _ := checkBranchConflict(config, folderName, branchSpec{anyRepo: branchName}, repoNames); conflictFolder != "" {
+		code := fail("branch '%s' is already active in folder '%s'", branchName, conflictFolder)
+		fmt.Fprintf(os.Stderr, "Remove the existing worktrees first with: worktree_plus remove -folder %s\n", conflictFolder)
+		return code
+	}
+
+	// Switching would carry uncommitted changes over to the new branch, or fail halfway
+	if err := requireClean(repos); err != nil {
+		return fail("refusing to switch folder '%s': %v; commit or stash them first", folderName, err)
+	}
+
+	targetDirs := make([]string, len(repos))
+	for i, repo := range repos {
+		targetDirs[i] = repo.Dir
+	}
+
+	fmt.Fprintf(textOut, "Switching folder '%s' from branch '%s' to '%s' in %d directories\n", folderName, previousBranch, branchName, len(targetDirs))
+
+	// Ctrl-C cancels repos that haven't started yet
+	ctx, stop := interruptContext()
+	defer stop()
+
+	var switchedMu sync.Mutex
+	switched := make(map[string]createResult)
+	startedAt := time.Now()
+
+	results := runRepos(ctx, targetDirs, resolveJobs(config, *jobsFlag), func(ctx context.Context, dir string, log *repoLog) (string, error) {
+		record, _ := findRepoRecord(info, dir)
+		if record.Path == "" {
+			record.Path = getWorktreePath(dir, folderName)
+		}
+		result, err := switchWorktree(log, dir, record.Path, branchName, createOptions{
+			BaseRef:      resolveBaseRef(config, filepath.Base(dir), *fromFlag),
+			Remotes:      resolveRemotes(config, filepath.Base(dir)),
+			Lookup:       remoteLookup{Offline: offline, Timeout: remoteTimeout},
+			FetchTimeout: *fetchTimeoutFlag,
+			Ignored:      resolveIgnoredRules(config, filepath.Base(dir)),
+		})
+		if err == nil {
+			switchedMu.Lock()
+			switched[dir] = result
+			switchedMu.Unlock()
+		}
+		if out != nil && out.stream {
+			out.write(line("repo_result", toJSONRepoResult(repoResult{Dir: dir, Detail: result.summary(), Err: err}, result.Path, result.BaseRef)))
+		}
+		return result.summary(), err
+	})
+	if len(results) > 1 {
+		printSummaryTable(results)
+	}
+
+	// The folder follows the new branch as soon as any repo is on it; the rest show up in status
+	if len(switched) > 0 {
+		var records []RepoRecord
+		for _, repo := range repos {
+			if result, ok := switched[repo.Dir]; ok {
+				records = append(records, RepoRecord{Name: repo.Name, Dir: repo.Dir, Path: result.Path, Branch: result.Branch, BaseRef: result.BaseRef})
+			}
+		}
+		switchFolder(config, folderName, branchName)
+		recordRepos(config, folderName, records)
+		if ws.save() {
+			fmt.Fprintf(textOut, "\nFolder '%s' is now on branch '%s' (was '%s')\n", folderName, branchName, previousBranch)
+		}
+	}
+	failed := countFailed(results)
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d repositories were not switched and are still on '%s'\n", failed, len(targetDirs), previousBranch)
+	}
+
+	if out != nil {
+		doc := newRunResult("switch", folderName, branchName, startedAt, results, false)
+		for i, r := range results {
+			doc.Repos[i].Path, doc.Repos[i].BaseRef = switched[r.Dir].Path, switched[r.Dir].BaseRef
+		}
+		out.write(doc)
+	}
+
+	return exitCodeFor(ctx, failed)
+}
+
+// switchWorktree checks out branchName in the existing worktree at worktreePath, using an
+// existing local branch, tracking a remote one, or creating it from the base like createWorktree
+func switchWorktree(log *repoLog, dir, worktreePath, branchName string, opts createOptions) (createResult, error) {
+	result := createResult{Branch: branchName, Path: worktreePath}
+
+	log.Printf("Switching worktree at %s to '%s'", worktreePath, branchName)
+
+	if _, err := os.Stat(worktreePath); err != nil {
+		return result, fmt.Errorf("worktree does not exist at %s", worktreePath)
+	}
+
+	if err := planBranch(log, dir, branchName, opts, &result); err != nil {
+		return result, err
+	}
+
+	var cmd *exec.Cmd
+	switch result.Source {
+	case "local":
+		cmd = exec.Command("git", "switch", branchName)
+	case "remote":
+		cmd = exec.Command("git", "switch", "--track", "-c", branchName, result.Remote)
+	default:
+		// The base was resolved in the main checkout, where HEAD may differ from the worktree's
+		cmd = exec.Command("git", "switch", "-c", branchName, result.Commit)
+	}
+	cmd.Dir = worktreePath
+	cmd.Stdout = log.Stdout()
+	cmd.Stderr = log.Stderr()
+
+	if err := cmd.Run(); err != nil {
+		return result, fmt.Errorf("git switch failed: %w", err)
+	}
+
+	log.Printf("Switched successfully")
+
+	// The new branch may ignore items the old one didn't
+	if err := setupIgnoredItems(log, dir, worktreePath, opts.Ignored); err != nil {
+		log.Warnf("failed to set up some gitignored items: %v", err)
+	}
+
+	return result, nil
+}
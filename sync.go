@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Sync modes
+const (
+	syncRebase = "rebase"
+	syncMerge  = "merge"
+)
+
+// errConflict is wrapped by sync errors for repos that stopped on a conflict
+var errConflict = errors.New("conflict")
+
+func runSync(args []string) int {
+	fs := newFlagSet("sync")
+	mergeFlag := fs.Bool("merge", false, "Merge the base into each branch instead of rebasing onto it")
+	keepGoingFlag := fs.Bool("keep-going", false, "Sync the other repos when one conflicts instead of stopping there")
+	continueFlag := fs.Bool("continue", false, "Continue a stopped sync after resolving and staging the conflicts")
+	abortFlag := fs.Bool("abort", false, "Abort the rebases or merges of a stopped sync and forget the rest")
+	offlineFlag := fs.Bool("offline", false, "Don't fetch; sync onto the remote branches as last fetched")
+	fetchTimeoutFlag := fs.Duration("fetch-timeout", defaultFetchTimeout, "Timeout for each git fetch")
+	jobsFlag := fs.Int("jobs", 0, "Number of repos to process in parallel with -keep-going (default 4, or jobs in config); otherwise repos are synced one by one")
+	formatFlag := addFormatFlag(fs)
+
+	args, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(args) != 1 {
+		return usageError(fs, "expected exactly one folder name")
+	}
+	if *continueFlag && *abortFlag {
+		return usageError(fs, "-continue and -abort can't be used together")
+	}
+	folderName := args[0]
+
+	out, err := setupOutput(*formatFlag)
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+	config := ws.config
+	target, err := loadFolderTarget(ws, folderName)
+	if err != nil {
+		return fail("%v", err)
+	}
+	info := target.info
+
+	// Ctrl-C cancels repos that haven't started yet
+	ctx, stop := interruptContext()
+	defer stop()
+	startedAt := time.Now()
+
+	var results []repoResult
+	switch {
+	case *abortFlag:
+		if info.Sync == nil {
+			return fail("no sync of folder '%s' in progress", folderName)
+		}
+		state := info.Sync
+		n := len(state.Conflicted)
+		fmt.Fprintf(textOut, "Aborting the %s in %d %s of folder '%s'\n", state.Mode, n, plural(n, "repository", "repositories"), folderName)
+		results = runRepos(ctx, state.Conflicted, resolveJobs(config, *jobsFlag), func(ctx context.Context, dir string, log *repoLog) (string, error) {
+			return abortSync(log, target.records[dir].Path, state.Mode)
+		})
+		printSummaryTable(results)
+
+		info.Sync = nil
+		if ws.save() {
+			fmt.Fprintln(textOut, "\nSync aborted. Repos that were already synced keep their new history.")
+		}
+
+	case *continueFlag:
+		if info.Sync == nil {
+			return fail("no sync of folder '%s' in progress", folderName)
+		}
+		state := info.Sync
+		fmt.Fprintf(textOut, "Continuing the %s in folder '%s'\n", state.Mode, folderName)
+
+		// Finish the conflicted repos first, then the ones that were never started
+		results = runRepos(ctx, state.Conflicted, resolveJobs(config, *jobsFlag), func(ctx context.Context, dir string, log *repoLog) (string, error) {
+			return continueSync(log, target.records[dir].Path, state.Mode)
+		})
+		pending := state.Pending
+		if countFailed(results) == 0 || state.KeepGoing {
+			results = append(results, syncRepos(ctx, config, target, pending, state, *jobsFlag)...)
+		} else {
+			for _, dir := range pending {
+				results = append(results, repoResult{Dir: dir, Err: errCancelled})
+			}
+		}
+		printSummaryTable(results)
+		saveSyncState(ws, folderName, state, results)
+
+	default:
+		if info.Sync != nil {
+			return fail("a %s of folder '%s' is in progress; use -continue or -abort", info.Sync.Mode, folderName)
+		}
+		state := &SyncState{Mode: syncRebase, KeepGoing: *keepGoingFlag}
+		if *mergeFlag {
+			state.Mode = syncMerge
+		}
+
+		// A rebase or merge needs clean worktrees
+		repos := make([]RepoRecord, len(target.dirs))
+		for i, dir := range target.dirs {
+			repos[i] = target.records[dir]
+		}
+		if err := requireClean(repos); err != nil {
+			return fail("refusing to sync folder '%s': %v; commit or stash them first", folderName, err)
+		}
+
+		if *offlineFlag || config.Offline {
+			fmt.Fprintln(textOut, "Offline mode: skipping fetch")
+		} else if failed := fetchAll(config, target.dirs, *fetchTimeoutFlag); failed > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: fetch failed in %d of %d repositories, continuing with local state\n", failed, len(target.dirs))
+		}
+
+		fmt.Fprintf(textOut, "\nSyncing %d worktrees of folder '%s' (%s)\n", len(target.dirs), folderName, state.Mode)
+		results = syncRepos(ctx, config, target, target.dirs, state, *jobsFlag)
+		printSummaryTable(results)
+		saveSyncState(ws, folderName, state, results)
+	}
+
+	if out != nil {
+		doc := newRunResult("sync", folderName, info.Branch, startedAt, results, false)
+		for i, r := range results {
+			doc.Repos[i].Path, doc.Repos[i].BaseRef = target.records[r.Dir].Path, target.records[r.Dir].BaseRef
+		}
+		out.write(doc)
+	}
+
+	return exitCodeFor(ctx, countFailed(results))
+}
+
+// syncRepos rebases or merges the worktrees of dirs onto their bases. Unless the state
+// says to keep going, repos are synced one at a time and the first conflict stops the rest.
+func syncRepos(ctx context.Context, config *Config, target *folderTarget, dirs []string, state *SyncState, jobsFlag int) []repoResult {
+	jobs := 1
+	if state.KeepGoing {
+		jobs = resolveJobs(config, jobsFlag)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var once sync.Once
+	return runRepos(ctx, dirs, jobs, func(ctx context.Context, dir string, log *repoLog) (string, error) {
+		detail, err := syncWorktree(log, target.records[dir], state.Mode)
+		if errors.Is(err, errConflict) && !state.KeepGoing {
+			once.Do(func() {
+				log.Printf("Stopping here, the remaining repos are synced by -continue")
+				cancel()
+			})
+		}
+		return detail, err
+	})
+}
+
+// saveSyncState records which repos still need attention, or clears the state when all
+// are done, and tells the user how to go on
+func saveSyncState(ws *workspace, folderName string, state *SyncState, results []repoResult) {
+	state.Conflicted, state.Pending = nil, nil
+	for _, r := range results {
+		switch {
+		case errors.Is(r.Err, errConflict):
+			state.Conflicted = append(state.Conflicted, r.Dir)
+		case errors.Is(r.Err, errCancelled):
+			state.Pending = append(state.Pending, r.Dir)
+		}
+	}
+
+	info := ws.config.Folders[folderName]
+	info.Sync = nil
+	if len(state.Conflicted) > 0 || len(state.Pending) > 0 {
+		info.Sync = state
+	}
+	if !ws.save() {
+		return
+	}
+
+	if info.Sync == nil {
+		fmt.Fprintf(textOut, "\nSync of folder '%s' finished\n", folderName)
+		return
+	}
+	fmt.Fprintln(textOut)
+	if len(state.Conflicted) > 0 {
+		fmt.Fprintf(textOut, "Resolve the conflicts in %d %s and stage them with git add, then run:\n", len(state.Conflicted), plural(len(state.Conflicted), "repository", "repositories"))
+	} else {
+		fmt.Fprintf(textOut, "%d %s not synced yet. To go on, run:\n", len(state.Pending), plural(len(state.Pending), "repository was", "repositories were"))
+	}
+	fmt.Fprintf(textOut, "  worktree_plus sync -continue %s\n", folderName)
+	fmt.Fprintf(textOut, "Or give up on the unfinished repos with: worktree_plus sync -abort %s\n", folderName)
+}
+
+// syncBase returns the ref a repo's branch is synced onto: its recorded base, or the
+// upstream of the base when that is a local branch, since a local base is rarely up to date
+func syncBase(repo RepoRecord) (string, error) {
+	if repo.BaseRef == "" || repo.BaseRef == "HEAD" {
+		return "", fmt.Errorf("no base ref recorded for this repo")
+	}
+	if branchExists(repo.Path, repo.BaseRef) {
+		if upstream := upstreamOf(repo.Path, repo.BaseRef); upstream != "" {
+			return upstream, nil
+		}
+	}
+	return repo.BaseRef, nil
+}
+
+// syncWorktree rebases the worktree's branch onto its base, or merges the base into it
+func syncWorktree(log *repoLog, repo RepoRecord, mode string) (string, error) {
+	if _, err := os.Stat(repo.Path); err != nil {
+		return "", fmt.Errorf("worktree does not exist at %s", repo.Path)
+	}
+	if currentBranch(repo.Path) == "" {
+		return "", fmt.Errorf("HEAD is detached, nothing to sync")
+	}
+
+	base, err := syncBase(repo)
+	if err != nil {
+		return "", err
+	}
+	_, behind, err := aheadBehind(repo.Path, base)
+	if err != nil {
+		return "", err
+	}
+	if behind == 0 {
+		log.Printf("Already up to date with '%s'", base)
+		return "up to date with " + base, nil
+	}
+
+	var args []string
+	if mode == syncMerge {
+		log.Printf("Merging %d %s from '%s'", behind, plural(behind, "commit", "commits"), base)
+		args = []string{"merge", "--no-edit", base}
+	} else {
+		log.Printf("Rebasing onto '%s' (%d new %s)", base, behind, plural(behind, "commit", "commits"))
+		args = []string{"rebase", base}
+	}
+	if err := runSyncGit(log, repo.Path, args...); err != nil {
+		return "", conflictError(repo.Path, mode, err)
+	}
+
+	if mode == syncMerge {
+		return "merged " + base, nil
+	}
+	return "rebased onto " + base, nil
+}
+
+// continueSync finishes a rebase or merge whose conflicts the user has resolved
+func continueSync(log *repoLog, worktreePath, mode string) (string, error) {
+	if !syncInProgress(worktreePath, mode) {
+		log.Printf("No %s in progress, it was already finished", mode)
+		return "already finished", nil
+	}
+	if files, err := unmergedFiles(worktreePath); err != nil {
+		return "", err
+	} else if len(files) > 0 {
+		return "", fmt.Errorf("%w: %d unresolved %s: %s", errConflict, len(files), plural(len(files), "file", "files"), summarizeList(files, 5))
+	}
+
+	log.Printf("Continuing the %s", mode)
+	if err := runSyncGit(log, worktreePath, mode, "--continue"); err != nil {
+		return "", conflictError(worktreePath, mode, err)
+	}
+	return "continued " + mode, nil
+}
+
+// abortSync aborts a stopped rebase or merge, restoring the branch to where it was
+func abortSync(log *repoLog, worktreePath, mode string) (string, error) {
+	if !syncInProgress(worktreePath, mode) {
+		log.Printf("No %s in progress", mode)
+		return "nothing to abort", nil
+	}
+	log.Printf("Aborting the %s", mode)
+	if err := runSyncGit(log, worktreePath, mode, "--abort"); err != nil {
+		return "", fmt.Errorf("git %s --abort failed: %w", mode, err)
+	}
+	return "aborted " + mode, nil
+}
+
+// runSyncGit runs a rebase or merge command. The editor is disabled so that continuing
+// keeps the existing commit messages instead of waiting for input.
+func runSyncGit(log *repoLog, worktreePath string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = worktreePath
+	cmd.Env = append(os.Environ(), "GIT_EDITOR=true")
+	cmd.Stdout = log.Stdout()
+	cmd.Stderr = log.Stderr()
+	return cmd.Run()
+}
+
+// syncInProgress reports whether a rebase or merge, per mode, has stopped in the worktree
+func syncInProgress(worktreePath, mode string) bool {
+	if mode == syncMerge {
+		return mergeInProgress(worktreePath)
+	}
+	return rebaseInProgress(worktreePath)
+}
+
+// conflictError describes a failed rebase or merge, wrapping errConflict when it stopped
+// on conflicts and is waiting to be continued
+func conflictError(worktreePath, mode string, err error) error {
+	if !syncInProgress(worktreePath, mode) {
+		return fmt.Errorf("git %s failed: %w", mode, err)
+	}
+	files, _ := unmergedFiles(worktreePath)
+	if len(files) == 0 {
+		return fmt.Errorf("%w: %s stopped", errConflict, mode)
+	}
+	return fmt.Errorf("%w in %d %s: %s", errConflict, len(files), plural(len(files), "file", "files"), summarizeList(files, 5))
+}
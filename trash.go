@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// trashDirName is the directory in the workspace holding removed files until they are purged
+const trashDirName = ".worktree_plus_trash"
+
+// trashManifestName is the file in each trash entry describing what it holds
+const trashManifestName = "trash.json"
+
+// trashEntry is everything trashed by one removal, stored in its own directory
+type trashEntry struct {
+	ID        string      `json:"id"`
+	Folder    string      `json:"folder"`
+	Branch    string      `json:"branch"`
+	TrashedAt time.Time   `json:"trashed_at"`
+	Items     []trashItem `json:"items"`
+
+	root string // workspace directory
+	dir  string // entry directory, empty until the first item is moved in
+	mu   sync.Mutex
+}
+
+// trashItem is a file or directory that was moved into the trash
+type trashItem struct {
+	Path     string `json:"path"`               // location inside the entry directory
+	Original string `json:"original"`           // absolute path it was moved from
+	Repo     string `json:"repo,omitempty"`     // repo whose worktree it came from
+	Worktree string `json:"worktree,omitempty"` // worktree it belongs in; empty for leftover folder files
+}
+
+// newTrashEntry starts an entry for files removed from a folder. Nothing is written
+// until the first item is moved in.
+func newTrashEntry(root, folderName, branchName string) *trashEntry {
+	return &trashEntry{Folder: folderName, Branch: branchName, root: root}
+}
+
+// trashRoot returns the trash directory of the workspace
+func trashRoot(root string) string {
+	return filepath.Join(root, trashDirName)
+}
+
+// create allocates the entry directory, named after the time and folder
+func (e *trashEntry) create() error {
+	e.TrashedAt = time.Now()
+	base := e.TrashedAt.Format("20060102-150405") + "-" + strings.ReplaceAll(e.Folder, "/", "-")
+	if err := os.MkdirAll(trashRoot(e.root), 0755); err != nil {
+		return err
+	}
+
+	// Two removals of the same folder within a second get numbered ids
+	for n := 1; ; n++ {
+		id := base
+		if n > 1 {
+			id = fmt.Sprintf("%s-%d", base, n)
+		}
+		dir := filepath.Join(trashRoot(e.root), id)
+		err := os.Mkdir(dir, 0755)
+		if err == nil {
+			e.ID, e.dir = id, dir
+			return nil
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+	}
+}
+
+// move moves src into the entry at rel, a slash-separated path inside the entry directory
+func (e *trashEntry) move(src, rel string, item trashItem) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.dir == "" {
+		if err := e.create(); err != nil {
+			return fmt.Errorf("cannot create trash entry: %w", err)
+		}
+	}
+
+	dst := filepath.Join(e.dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+
+	item.Path, item.Original = rel, src
+	e.Items = append(e.Items, item)
+	return nil
+}
+
+// save writes the entry's manifest. An entry nothing was moved into isn't saved.
+func (e *trashEntry) save() error {
+	if e.dir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(e.dir, trashManifestName), data, 0644)
+}
+
+// trashDirtyFiles moves the uncommitted files of a worktree into the trash so that a
+// forced removal doesn't destroy them. Returns how many files were moved.
+func trashDirtyFiles(entry *trashEntry, repoName, worktreePath string) (int, error) {
+	files, err := dirtyFiles(worktreePath)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, file := range files {
+		src := filepath.Join(worktreePath, filepath.FromSlash(file))
+		item := trashItem{Repo: repoName, Worktree: worktreePath}
+		if err := entry.move(src, "repos/"+repoName+"/"+file, item); err != nil {
+			return i, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return len(files), nil
+}
+
+// loadTrash reads every entry in the workspace's trash, most recent first
+func loadTrash(root string) ([]*trashEntry, error) {
+	dirs, err := os.ReadDir(trashRoot(root))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []*trashEntry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		dir := filepath.Join(trashRoot(root), d.Name())
+		data, err := os.ReadFile(filepath.Join(dir, trashManifestName))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping trash entry %s: %v\n", d.Name(), err)
+			continue
+		}
+		entry := &trashEntry{root: root, dir: dir}
+		if err := json.Unmarshal(data, entry); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping trash entry %s: %v\n", d.Name(), err)
+			continue
+		}
+		entry.ID = d.Name()
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].TrashedAt.After(entries[j].TrashedAt)
+	})
+	return entries, nil
+}
+
+// restore moves every item back to where it came from. Items whose destination is
+// taken, or whose worktree no longer exists, stay in the trash; the returned notes
+// say why. The entry is deleted once it is empty.
+func (e *trashEntry) restore() (int, []string, error) {
+	var kept []trashItem
+	var notes []string
+	restored := 0
+
+	for _, item := range e.Items {
+		// Recreating the worktree directory by hand would block recreating the worktree
+		if item.Worktree != "" {
+			if _, err := os.Stat(item.Worktree); err != nil {
+				kept = append(kept, item)
+				notes = append(notes, fmt.Sprintf("%s: worktree %s does not exist, recreate the folder first", item.Original, item.Worktree))
+				continue
+			}
+		}
+		src := filepath.Join(e.dir, filepath.FromSlash(item.Path))
+		if _, err := os.Lstat(item.Original); err == nil {
+			kept = append(kept, item)
+			notes = append(notes, fmt.Sprintf("%s: already exists, trashed copy kept at %s", item.Original, src))
+			continue
+		}
+
+		err := os.MkdirAll(filepath.Dir(item.Original), 0755)
+		if err == nil {
+			err = os.Rename(src, item.Original)
+		}
+		if err != nil {
+			kept = append(kept, item)
+			notes = append(notes, fmt.Sprintf("%s: %v", item.Original, err))
+			continue
+		}
+		restored++
+	}
+
+	e.Items = kept
+	if len(kept) == 0 {
+		return restored, notes, os.RemoveAll(e.dir)
+	}
+	return restored, notes, e.save()
+}
+
+// parseAge parses an age like "30d" or any Go duration such as "12h"
+func parseAge(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age '%s'", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age '%s' (expected e.g. 30d or 12h)", value)
+	}
+	return d, nil
+}
+
+// trashCommands lists the subcommands of trash
+var trashCommands []*command
+
+func init() {
+	trashCommands = []*command{
+		{name: "trash list", args: "", summary: "List trashed files by removal, most recent first", run: runTrashList},
+		{name: "trash restore", args: "<id>", summary: "Move a trash entry's files back to where they were removed from", run: runTrashRestore},
+		{name: "trash purge", args: "[flags]", summary: "Permanently delete trash entries", run: runTrashPurge},
+	}
+}
+
+func runTrash(args []string) int {
+	if len(args) > 0 {
+		for _, cmd := range trashCommands {
+			if cmd.name == "trash "+args[0] {
+				return cmd.run(args[1:])
+			}
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "Usage: worktree_plus trash <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, cmd := range trashCommands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", strings.TrimPrefix(cmd.name, "trash "), cmd.summary)
+	}
+	switch {
+	case len(args) == 0:
+		return exitUsage
+	case args[0] == "-h" || args[0] == "-help" || args[0] == "--help":
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "\nUnknown trash command '%s'\n", args[0])
+		return exitUsage
+	}
+}
+
+func runTrashList(args []string) int {
+	fs := commandFlagSet(trashCommands[0])
+
+	args, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(args) > 0 {
+		return usageError(fs, "unexpected argument '%s'", args[0])
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	entries, err := loadTrash(ws.cwd)
+	if err != nil {
+		return fail("reading trash: %v", err)
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(textOut, "Trash is empty.")
+		return exitOK
+	}
+
+	rows := [][]string{{"ID", "FOLDER", "BRANCH", "TRASHED", "ITEMS"}}
+	for _, e := range entries {
+		rows = append(rows, []string{e.ID, e.Folder, e.Branch, formatTimeAgo(e.TrashedAt), describeTrashItems(e.Items)})
+	}
+	printTable(rows, "")
+	return exitOK
+}
+
+// describeTrashItems summarizes an entry's items per repo, e.g. "api: 3 files, folder: 1 item"
+func describeTrashItems(items []trashItem) string {
+	var order []string
+	counts := make(map[string]int)
+	for _, item := range items {
+		source := item.Repo
+		if source == "" {
+			source = "folder"
+		}
+		if counts[source] == 0 {
+			order = append(order, source)
+		}
+		counts[source]++
+	}
+
+	parts := make([]string, len(order))
+	for i, source := range order {
+		n := counts[source]
+		if source == "folder" {
+			parts[i] = fmt.Sprintf("folder: %d %s", n, plural(n, "item", "items"))
+		} else {
+			parts[i] = fmt.Sprintf("%s: %d %s", source, n, plural(n, "file", "files"))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func runTrashRestore(args []string) int {
+	fs := commandFlagSet(trashCommands[1])
+
+	args, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(args) != 1 {
+		return usageError(fs, "expected exactly one trash id")
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	entries, err := loadTrash(ws.cwd)
+	if err != nil {
+		return fail("reading trash: %v", err)
+	}
+	var entry *trashEntry
+	for _, e := range entries {
+		if e.ID == args[0] {
+			entry = e
+		}
+	}
+	if entry == nil {
+		return fail("no trash entry '%s' (see: worktree_plus trash list)", args[0])
+	}
+
+	restored, notes, err := entry.restore()
+	fmt.Fprintf(textOut, "Restored %d of %d items from '%s' (folder '%s', branch '%s')\n", restored, restored+len(entry.Items), entry.ID, entry.Folder, entry.Branch)
+	for _, note := range notes {
+		fmt.Fprintf(textOut, "  ! %s\n", note)
+	}
+	if err != nil {
+		return fail("updating trash entry: %v", err)
+	}
+	if len(entry.Items) > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func runTrashPurge(args []string) int {
+	fs := commandFlagSet(trashCommands[2])
+	olderThanFlag := fs.String("older-than", "", "Only purge entries trashed longer ago than this, e.g. 30d or 12h")
+	allFlag := fs.Bool("all", false, "Purge every entry")
+
+	args, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(args) > 0 {
+		return usageError(fs, "unexpected argument '%s'", args[0])
+	}
+	if *olderThanFlag == "" && !*allFlag {
+		return usageError(fs, "expected -older-than or -all")
+	}
+
+	var cutoff time.Time
+	if *olderThanFlag != "" {
+		age, err := parseAge(*olderThanFlag)
+		if err != nil {
+			return usageError(fs, "%v", err)
+		}
+		cutoff = time.Now().Add(-age)
+	} else {
+		cutoff = time.Now()
+	}
+
+	ws, err := loadWorkspace()
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	entries, err := loadTrash(ws.cwd)
+	if err != nil {
+		return fail("reading trash: %v", err)
+	}
+
+	var errs []error
+	purged := 0
+	for _, e := range entries {
+		if !e.TrashedAt.Before(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(e.dir); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", e.ID, err))
+			continue
+		}
+		purged++
+		fmt.Fprintf(textOut, "Purged %s (folder '%s', %s)\n", e.ID, e.Folder, formatTimeAgo(e.TrashedAt))
+	}
+	fmt.Fprintf(textOut, "Purged %d of %d trash entries\n", purged, len(entries))
+
+	if err := errors.Join(errs...); err != nil {
+		return fail("%v", err)
+	}
+	return exitOK
+}
@@ -1,12 +1,54 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
+// createOptions controls how createWorktree picks the starting point for a branch
+type createOptions struct {
+	BaseRef      string        // ref new branches start from; empty means the main checkout's HEAD
+	Remotes      []string      // remotes searched for an existing branch, in order
+	Lookup       remoteLookup  // how remotes are checked for an existing branch
+	FetchTimeout time.Duration // limit for fetching a remote branch before tracking it
+	ExistingOnly bool          // fail with errBranchNotFound instead of creating a new branch
+	Ignored      []IgnoredRule // what the worktree gets for each gitignored item
+}
+
+// errBranchNotFound is returned by createWorktree when the branch exists neither locally
+// nor on a remote and createOptions.ExistingOnly forbids creating it
+var errBranchNotFound = errors.New("branch no longer exists")
+
+// createResult describes how createWorktree obtained the branch in one repository
+type createResult struct {
+	Branch  string
+	Path    string // worktree path
+	Existed bool   // worktree was already there, nothing was done
+	Source  string // "local", "remote" or "new"
+	Remote  string // remote the branch tracks, when Source is "remote"
+	BaseRef string // ref the branch is based on; a new branch started from it
+	Commit  string // abbreviated commit a new branch started from
+}
+
+// summary describes the result in a few words for the summary table
+func (r createResult) summary() string {
+	switch {
+	case r.Existed:
+		return "already exists"
+	case r.Source == "local":
+		return "existing local branch"
+	case r.Source == "remote":
+		return "tracking " + r.Remote
+	default:
+		return fmt.Sprintf("new branch from %s (%s)", r.BaseRef, r.Commit)
+	}
+}
+
 // getWorktreePath calculates the worktree path: ../../<folder>/<dirname>
 func getWorktreePath(dir, folderName string) string {
 	dirName := filepath.Base(dir)
@@ -15,90 +57,307 @@ func getWorktreePath(dir, folderName string) string {
 	return filepath.Join(grandparentDir, folderName, dirName)
 }
 
-// createWorktree creates a worktree for the given directory, folder name, and branch
-func createWorktree(dir, folderName, branchName string) error {
-	dirName := filepath.Base(dir)
+// samePath reports whether two paths name the same existing file or directory
+func samePath(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+// createWorktree creates a worktree for the given directory, folder name, and branch.
+// New branches start from opts.BaseRef, or from the main checkout's HEAD if it is empty.
+func createWorktree(log *repoLog, dir, folderName, branchName string, opts createOptions) (createResult, error) {
 	worktreePath := getWorktreePath(dir, folderName)
+	result := createResult{Branch: branchName, Path: worktreePath}
 
-	fmt.Printf("\n[%s] Creating worktree at %s\n", dirName, worktreePath)
+	log.Printf("Creating worktree at %s", worktreePath)
 
 	// Check if worktree already exists
 	if _, err := os.Stat(worktreePath); err == nil {
-		fmt.Printf("[%s] Worktree already exists at %s\n", dirName, worktreePath)
-		return nil
+		log.Printf("Worktree already exists at %s", worktreePath)
+		result.Existed = true
+		if current := currentBranch(worktreePath); current != "" && current != branchName {
+			log.Warnf("the worktree is on branch '%s', not '%s'", current, branchName)
+			result.Branch = current
+		}
+		result.BaseRef = defaultBase(dir, opts.BaseRef)
+		return result, nil
 	}
 
 	// Create parent directory for worktree if needed
 	worktreeParent := filepath.Dir(worktreePath)
 	if err := os.MkdirAll(worktreeParent, 0755); err != nil {
-		return fmt.Errorf("failed to create parent directory: %w", err)
+		return result, fmt.Errorf("failed to create parent directory: %w", err)
 	}
 
 	// Determine if branch exists locally, remotely, or needs to be created
+	if err := planBranch(log, dir, branchName, opts, &result); err != nil {
+		return result, err
+	}
+
 	var cmd *exec.Cmd
-	if branchExists(dir, branchName) {
-		// Branch exists locally, use it
-		fmt.Printf("[%s] Using existing local branch '%s'\n", dirName, branchName)
+	switch result.Source {
+	case "local":
 		cmd = exec.Command("git", "worktree", "add", worktreePath, branchName)
-	} else if remoteBranchExists(dir, branchName) {
-		// Branch exists on remote, track it
-		fmt.Printf("[%s] Tracking remote branch '%s'\n", dirName, branchName)
-		cmd = exec.Command("git", "worktree", "add", "--track", "-b", branchName, worktreePath, "origin/"+branchName)
-	} else {
-		// Branch doesn't exist, create it
-		fmt.Printf("[%s] Creating new branch '%s'\n", dirName, branchName)
-		cmd = exec.Command("git", "worktree", "add", "-b", branchName, worktreePath)
+	case "remote":
+		cmd = exec.Command("git", "worktree", "add", "--track", "-b", branchName, worktreePath, result.Remote)
+	default:
+		// A remote base like origin/main must not become the upstream; that comes with the first push
+		cmd = exec.Command("git", "worktree", "add", "--no-track", "-b", branchName, worktreePath, result.BaseRef)
 	}
 
 	cmd.Dir = dir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = log.Stdout()
+	cmd.Stderr = log.Stderr()
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("git worktree add failed: %w", err)
+		return result, fmt.Errorf("git worktree add failed: %w", err)
+	}
+
+	log.Printf("Worktree created successfully")
+
+	// Share, copy or start fresh the gitignored files/directories
+	if err := setupIgnoredItems(log, dir, worktreePath, opts.Ignored); err != nil {
+		log.Warnf("failed to set up some gitignored items: %v", err)
+	}
+
+	return result, nil
+}
+
+// planBranch decides how the repository in dir gets branchName: an existing local branch,
+// a remote branch to track (fetched first when needed), or a new branch from the base ref.
+// It fills in the result's Source, Remote, BaseRef and Commit.
+func planBranch(log *repoLog, dir, branchName string, opts createOptions, result *createResult) error {
+	match, onRemote, warnings := remoteMatch{}, false, []string(nil)
+	isLocal := branchExists(dir, branchName)
+	if !isLocal {
+		match, onRemote, warnings = findRemoteBranch(dir, opts.Remotes, branchName, opts.Lookup)
+		for _, w := range warnings {
+			log.Warnf("%s", w)
+		}
 	}
 
-	fmt.Printf("[%s] Worktree created successfully\n", dirName)
+	// The base is recorded for every repo, even when the branch already exists
+	baseDesc := defaultBase(dir, opts.BaseRef)
+	result.BaseRef = baseDesc
 
-	// Create symlinks for gitignored files/directories
-	if err := createIgnoredSymlinks(dir, worktreePath); err != nil {
-		fmt.Fprintf(os.Stderr, "[%s] Warning: failed to create some symlinks: %v\n", dirName, err)
+	if !isLocal && !onRemote && opts.ExistingOnly {
+		return errBranchNotFound
 	}
 
+	if isLocal {
+		// Branch exists locally, use it
+		log.Printf("Using existing local branch '%s'%s", branchName, ignoredBaseNote(opts.BaseRef))
+		result.Source = "local"
+	} else if onRemote {
+		// Branch exists on remote, fetch it so the remote-tracking ref exists, then track it
+		remoteRef := match.Remote + "/" + branchName
+		if !match.Local {
+			log.Printf("Fetching remote branch '%s'", remoteRef)
+			if err := fetchBranch(dir, match.Remote, branchName, opts.FetchTimeout); err != nil {
+				return fmt.Errorf("cannot fetch %s: %w", remoteRef, err)
+			}
+		}
+		log.Printf("Tracking remote branch '%s'%s", remoteRef, ignoredBaseNote(opts.BaseRef))
+		result.Source, result.Remote = "remote", remoteRef
+	} else {
+		// Branch doesn't exist, create it from the base ref
+		commit, err := resolveCommit(dir, baseDesc)
+		if err != nil {
+			return fmt.Errorf("cannot resolve base: %w", err)
+		}
+		log.Printf("Creating new branch '%s' from '%s' (%s)", branchName, baseDesc, commit)
+		result.Source, result.Commit = "new", commit
+	}
 	return nil
 }
 
-// removeWorktree removes a worktree for the given directory and folder name
-func removeWorktree(dir, folderName, branchName string) error {
-	dirName := filepath.Base(dir)
-	worktreePath := getWorktreePath(dir, folderName)
+// defaultBase returns the base recorded for a repo: baseRef if set, else the branch the
+// main checkout is on, else the commit a detached main checkout is at. A literal "HEAD"
+// would later point at the worktree's own HEAD.
+func defaultBase(dir, baseRef string) string {
+	if baseRef != "" {
+		return baseRef
+	}
+	if current := currentBranch(dir); current != "" {
+		return current
+	}
+	if commit, err := resolveCommit(dir, "HEAD"); err == nil {
+		return commit
+	}
+	return "HEAD"
+}
+
+// rollbackWorktree undoes what createWorktree did in one repository: it removes the
+// worktree, along with the symlinks inside it and its git dir, and deletes the
+// branch if createWorktree created it. A partially created worktree is cleaned up too.
+func rollbackWorktree(log *repoLog, dir string, result createResult) (string, error) {
+	if result.Existed || result.Source == "" {
+		return "nothing to undo", nil
+	}
+
+	var undone []string
+	if _, err := os.Lstat(result.Path); err == nil {
+		log.Printf("Removing worktree at %s", result.Path)
+		cmd := exec.Command("git", "worktree", "remove", "--force", result.Path)
+		cmd.Dir = dir
+		cmd.Stdout = log.Stdout()
+		cmd.Stderr = log.Stderr()
+		if err := cmd.Run(); err != nil {
+			// Not a registered worktree (git was interrupted), so delete it directly
+			if err := os.RemoveAll(result.Path); err != nil {
+				return "", fmt.Errorf("cannot remove %s: %w", result.Path, err)
+			}
+			prune := exec.Command("git", "worktree", "prune")
+			prune.Dir = dir
+			prune.Run()
+		}
+		undone = append(undone, "worktree removed")
+	}
+
+	// An existing local branch was only checked out, everything else was created by us
+	if result.Source != "local" && branchExists(dir, result.Branch) {
+		log.Printf("Deleting branch '%s'", result.Branch)
+		cmd := exec.Command("git", "branch", "-D", result.Branch)
+		cmd.Dir = dir
+		cmd.Stdout = log.Stdout()
+		cmd.Stderr = log.Stderr()
+		if err := cmd.Run(); err != nil {
+			return strings.Join(undone, ", "), fmt.Errorf("git branch -D failed: %w", err)
+		}
+		undone = append(undone, "branch deleted")
+	}
+
+	if len(undone) == 0 {
+		return "nothing to undo", nil
+	}
+	return strings.Join(undone, ", "), nil
+}
+
+// ignoredBaseNote explains that a requested base ref was not used because the branch already exists
+func ignoredBaseNote(baseRef string) string {
+	if baseRef == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (branch exists, base '%s' not used)", baseRef)
+}
 
-	fmt.Printf("\n[%s] Removing worktree at %s\n", dirName, worktreePath)
+// removeWorktree removes the worktree at worktreePath from the repository in dir.
+// Returns false if there was no worktree to remove.
+func removeWorktree(log *repoLog, dir, worktreePath string, force bool) (bool, error) {
+	log.Printf("Removing worktree at %s", worktreePath)
 
 	// Check if worktree exists
 	if _, err := os.Stat(worktreePath); os.IsNotExist(err) {
-		fmt.Printf("[%s] Worktree does not exist at %s\n", dirName, worktreePath)
-		return nil
+		log.Printf("Worktree does not exist at %s", worktreePath)
+		return false, nil
 	}
 
-	// Remove the worktree
-	cmd := exec.Command("git", "worktree", "remove", worktreePath)
+	// Remove the worktree; --force only when the user agreed to lose uncommitted work
+	args := []string{"worktree", "remove"}
+	if force {
+		args = append(args, "--force")
+	}
+	cmd := exec.Command("git", append(args, worktreePath)...)
 	cmd.Dir = dir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = log.Stdout()
+	cmd.Stderr = log.Stderr()
 
 	if err := cmd.Run(); err != nil {
-		// Try force remove if normal remove fails
-		fmt.Printf("[%s] Normal remove failed, trying force remove...\n", dirName)
-		cmd = exec.Command("git", "worktree", "remove", "--force", worktreePath)
-		cmd.Dir = dir
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("git worktree remove failed: %w", err)
+		return true, fmt.Errorf("git worktree remove failed: %w", err)
+	}
+
+	log.Printf("Worktree removed successfully")
+	return true, nil
+}
+
+// branchDeletion says how deleteBranch treats a folder's branch after its worktree is gone
+type branchDeletion struct {
+	Force         bool          // delete even if not merged
+	Remote        bool          // also delete the branch on its remote
+	Remotes       []string      // remotes to look for the branch on when its upstream is named differently
+	RemoteTimeout time.Duration // timeout for the push that deletes the remote branch
+}
+
+// deleteBranch deletes branchName in the repository in dir. Unless forced, a branch that
+// isn't merged into baseRef (or the base's upstream) is kept. Returns what was done.
+func deleteBranch(log *repoLog, dir, branchName, baseRef string, opts branchDeletion) (string, error) {
+	if !branchExists(dir, branchName) {
+		log.Printf("Branch '%s' does not exist", branchName)
+		return "branch not present", nil
+	}
+
+	// Look up the remote branch before the local one and its upstream setting are gone. Only
+	// a remote branch of the same name is ever deleted, and never the base or its upstream.
+	var remote string
+	if opts.Remote {
+		protected := map[string]bool{baseRef: true}
+		if baseRef != "" {
+			protected[upstreamOf(dir, baseRef)] = true
+		}
+		if upstream := upstreamOf(dir, branchName); strings.HasSuffix(upstream, "/"+branchName) && !protected[upstream] {
+			remote = strings.TrimSuffix(upstream, "/"+branchName)
+		} else {
+			for _, r := range opts.Remotes {
+				if !protected[r+"/"+branchName] && remoteTrackingBranchExists(dir, r, branchName) {
+					remote = r
+					break
+				}
+			}
 		}
 	}
 
-	fmt.Printf("[%s] Worktree removed successfully\n", dirName)
-	return nil
+	if !opts.Force {
+		var merged bool
+		var into []string
+		if baseRef != "" && baseRef != "HEAD" {
+			into = append(into, baseRef)
+			if upstream := upstreamOf(dir, baseRef); upstream != "" {
+				into = append(into, upstream)
+			}
+		}
+		for _, ref := range into {
+			if isMergedInto(dir, branchName, ref) {
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			if len(into) == 0 {
+				log.Warnf("keeping branch '%s', its base is unknown (use -force-delete-branch to delete it anyway)", branchName)
+				return "branch kept: base unknown", nil
+			}
+			log.Warnf("keeping branch '%s', it is not merged into '%s' (use -force-delete-branch to delete it anyway)", branchName, into[0])
+			return "branch kept: not merged", nil
+		}
+	}
+
+	log.Printf("Deleting branch '%s'", branchName)
+	cmd := exec.Command("git", "branch", "-D", branchName)
+	cmd.Dir = dir
+	cmd.Stdout = log.Stdout()
+	cmd.Stderr = log.Stderr()
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git branch -D failed: %w", err)
+	}
+	done := "branch deleted"
+
+	if opts.Remote {
+		if remote == "" {
+			log.Printf("Branch '%s' has no remote branch", branchName)
+			return done, nil
+		}
+		log.Printf("Deleting remote branch '%s/%s'", remote, branchName)
+		if _, err := runGitTimeout(dir, opts.RemoteTimeout, "push", remote, "--delete", branchName); err != nil {
+			return done, err
+		}
+		done += ", remote branch deleted"
+	}
+	return done, nil
 }